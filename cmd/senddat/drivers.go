@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rusq/senddat"
+)
+
+// driversCmd lists the embedded decoder drivers with the number of commands.
+func driversCmd(args []string) error {
+	fset := flag.NewFlagSet("drivers", flag.ExitOnError)
+	fset.Usage = func() {
+		out := fset.Output()
+		fmt.Fprintf(out, "Lists the embedded decoder drivers, that can be selected with -driver.\n\n")
+		fmt.Fprintf(out, "Usage: %s drivers\n", os.Args[0])
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() > 0 {
+		fset.Usage()
+		return errors.New("drivers takes no arguments")
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, name := range senddat.Drivers() {
+		specs, err := senddat.LoadDriver(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%d commands\n", name, len(specs))
+	}
+	return tw.Flush()
+}
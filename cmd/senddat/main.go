@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"image/png"
 	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/rusq/senddat"
+	"golang.org/x/text/language"
 )
 
 var params = struct {
@@ -17,20 +23,82 @@ var params = struct {
 	isTemplate bool
 	verbose    bool
 	reverse    bool
+	format     string
+	paperWidth int
+	encoding   senddat.ByteEncoding
+	transport  senddat.TransportOptions
+	yes        bool
+	responses  string
+	image      senddat.ImageOptions
+	include    stringList
+	env        stringList
+	dataFile   string
+	locale     string
+	codePage   string
+	profile    *senddat.Profile
+	vars       stringList
+	templates  stringList
+	entry      string
+	driver     string
+	subCSV     string
+	prefixFmt  string
 }{
 	output: "",
 	input:  "",
+	format: "text",
+	image:  senddat.DefaultImageOptions,
+}
+
+// subcommands are the commands that have their own flags.
+var subcommands = map[string]func(args []string) error{
+	"test":    testCmd,
+	"status":  statusCmd,
+	"drivers": driversCmd,
 }
 
 func init() {
 	flag.Usage = usage
 	flag.BoolVar(&params.isTemplate, "t", false, "treat input as a Go template")
-	flag.StringVar(&params.output, "o", "", "output file (default stdout)")
+	flag.StringVar(&params.output, "o", "", "output file or printer URI, i.e. tcp://192.168.1.50:9100 or serial:///dev/ttyUSB0?baud=38400 (default stdout)")
 	flag.BoolVar(&params.verbose, "v", os.Getenv("DEBUG") == "1", "enable verbose logging")
 	flag.BoolVar(&params.reverse, "r", false, "reverse the PRN file")
+	flag.StringVar(&params.format, "format", params.format, "reverse output `format`: text, dat, json, jsonl, hexdump, png")
+	flag.Var(&params.encoding, "bytes", "binary data `encoding` for json and jsonl formats: base64, hex")
+	flag.IntVar(&params.paperWidth, "paper", senddat.DefaultPaperWidth, "paper width in `dots` for the png format")
+	flag.DurationVar(&params.transport.ConnectTimeout, "connect-timeout", 10*time.Second, "printer connection `timeout`")
+	flag.DurationVar(&params.transport.WriteTimeout, "write-timeout", 30*time.Second, "printer write `timeout`")
+	flag.StringVar(&params.dataFile, "data", "", "template data `file`, JSON or YAML, implies -t")
+	flag.StringVar(&params.locale, "locale", "", "`locale` for the number formatting in templates, i.e. de-DE")
+	flag.StringVar(&params.codePage, "codepage", "", "transcode string literals to the code `page`, i.e. PC866, WPC1252, or \"auto\" to switch code pages for mixed scripts (default UTF-8)")
+	flag.Var(&params.vars, "var", "set the template variable, `key=value`, can be repeated, implies -t")
+	flag.Var(&params.templates, "T", "add the template `files` (glob or directory of .tmpl files) to the template set, later definitions override earlier ones, can be repeated, implies -t")
+	flag.StringVar(&params.entry, "entry", "", "`name` of the template to execute from the template set (default is the input template)")
+	flag.Func("profile", "printer profile `name` or YAML file, i.e. tm-m30ii, sets the paper width, code pages and commands ("+strings.Join(senddat.Profiles(), ", ")+")", func(s string) error {
+		pr, err := senddat.LoadProfile(s)
+		if err != nil {
+			return err
+		}
+		params.profile = pr
+		return nil
+	})
+	flag.StringVar(&params.driver, "driver", "", "decoder driver `name` or CSV file for the reverse mode, i.e. escpos-3.40 (default xprinter, or the driver of the profile), see \"senddat drivers\"")
+	flag.StringVar(&params.subCSV, "subcommands", "", "CSV `file` with the command functions for the reverse mode, in addition to the ESC/POS functions")
+	flag.StringVar(&params.prefixFmt, "prefix-format", "expr", "`format` of the command prefixes in the -driver and -subcommands CSV files: expr (senddat expressions, i.e. ESC \"@\") or hex (i.e. 1B 40)")
+	flag.Var(&params.include, "I", "add the `directory` to the include search path, can be repeated")
+	flag.Var(&params.env, "env", "allow the templates to read the environment `variable`, NAME or PREFIX*, can be repeated (default all)")
+	flag.BoolVar(&params.yes, "yes", false, "do not wait on key input (.) commands, for unattended runs")
+	flag.StringVar(&params.responses, "responses", "", "read the answers to key input (.) commands from the `file`, one per line")
+	flag.Var(&params.image.Dither, "dither", "default image dithering `algorithm`: threshold, floyd-steinberg, atkinson, bayer, jarvis")
 }
 
 func main() {
+	if cmd, ok := subcommand(os.Args[1:]); ok {
+		if err := cmd(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	flag.Parse()
 
 	if params.verbose {
@@ -43,16 +111,11 @@ func main() {
 
 	ctx := context.Background()
 
-	parseFn := senddat.Parse
-	if params.isTemplate {
-		parseFn = senddat.ParseFromTemplate
-	}
-
 	var err error
 	if params.reverse {
 		err = reverse(ctx, params.input, params.output)
 	} else {
-		err = parse(ctx, params.input, params.output, parseFn)
+		err = parse(ctx, params.input, params.output)
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -60,7 +123,7 @@ func main() {
 	}
 }
 
-func parse(_ context.Context, input string, output string, parseFn func(w io.Writer, r io.Reader) error) error {
+func parse(_ context.Context, input string, output string) error {
 	r, w, err := openFiles(input, output)
 	if err != nil {
 		return fmt.Errorf("failed to open files: %w", err)
@@ -68,51 +131,423 @@ func parse(_ context.Context, input string, output string, parseFn func(w io.Wri
 	defer r.Close()
 	defer w.Close()
 
-	if err := parseFn(w, r); err != nil {
+	keys, err := openKeyInput(input)
+	if err != nil {
+		return err
+	}
+	opts := senddat.Options{Image: params.image, IncludePath: params.include, CodePage: params.codePage, Profile: params.profile, Env: params.env}
+	if len(opts.Env) == 0 {
+		opts.Env = []string{"*"}
+	}
+	if params.locale != "" {
+		tag, err := language.Parse(params.locale)
+		if err != nil {
+			return fmt.Errorf("invalid locale: %w", err)
+		}
+		opts.Locale = tag
+	}
+	if keys != nil {
+		defer keys.Close()
+		opts.Input = keys
+	}
+	p := senddat.NewParser(opts)
+	parseFn := p.Parse
+	if len(params.templates) > 0 || params.entry != "" {
+		data, err := templateData()
+		if err != nil {
+			return err
+		}
+		parseFn = func(w io.Writer, r io.Reader) error {
+			return executeTemplateSet(p, w, r, input, data)
+		}
+	} else if params.isTemplate || params.dataFile != "" || len(params.vars) > 0 {
+		data, err := templateData()
+		if err != nil {
+			return err
+		}
+		parseFn = func(w io.Writer, r io.Reader) error {
+			return p.ParseFromTemplateWithData(w, r, data)
+		}
+	}
+
+	var (
+		out  io.Writer = w
+		wait           = func() {}
+	)
+	if params.profile != nil {
+		// the output is checked against the profile as it is sent, so that
+		// the delays and key input are not held back.
+		var cw io.Writer
+		cw, wait = checkProfile(params.profile)
+		out = io.MultiWriter(w, cw)
+	}
+	err = parseFn(out, r)
+	wait()
+	if err != nil {
 		return fmt.Errorf("failed to parse input: %w", err)
 	}
 	slog.Info("Data sent successfully", "output", output, "input", input)
 	return nil
 }
 
-func stringRenderFn(w io.Writer) func(senddat.Entry) error {
-	return func(entry senddat.Entry) error {
-		_, err := fmt.Fprintln(w, entry.String())
+// checkProfile returns the writer, that decodes the output on the side and
+// warns about the commands that the printer of the profile does not support.
+// The returned function closes the writer and waits for the check to finish.
+func checkProfile(pr *senddat.Profile) (io.Writer, func()) {
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// the output must not block if the check stops early.
+		defer io.Copy(io.Discard, r)
+
+		interp, err := senddat.NewInterpreter(r, pr.CommandSpecs())
+		if err != nil {
+			slog.Warn("profile check is disabled", "profile", pr.Name, "error", err)
+			return
+		}
+		// unknown commands are not an error for the check.
+		interp.Lossless = true
+		for entry, err := range interp.All() {
+			if err != nil {
+				slog.Warn("failed to decode output, profile check stopped", "profile", pr.Name, "error", err)
+				return
+			}
+			if err := pr.Check(entry); err != nil {
+				slog.Warn("unsupported command", "profile", pr.Name, "offset", entry.Offset, "error", err)
+			}
+		}
+	}()
+	return w, func() {
+		w.Close()
+		<-done
+	}
+}
+
+// executeTemplateSet loads the input template, unless the entry template is
+// given without the input, and the template set, and executes the entry
+// template.
+func executeTemplateSet(p *senddat.Parser, w io.Writer, r io.Reader, input string, data any) error {
+	ts := p.NewTemplateSet()
+	entry := params.entry
+	if input != "" || entry == "" {
+		name := "stdin"
+		if input != "" && input != "-" {
+			name = filepath.Base(input)
+		}
+		if err := ts.Add(name, r); err != nil {
+			return err
+		}
+		if entry == "" {
+			entry = name
+		}
+	}
+	if err := ts.AddFiles(params.templates...); err != nil {
+		return err
+	}
+	return ts.Execute(w, entry, data)
+}
+
+// templateData loads the template data file, and sets the variables.
+func templateData() (any, error) {
+	var data any
+	if params.dataFile != "" {
+		var err error
+		if data, err = senddat.LoadTemplateData(params.dataFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range params.vars {
+		var err error
+		if data, err = senddat.SetTemplateVar(data, v); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// isFlagSet returns true if the flag is set on the command line.
+func isFlagSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// openKeyInput opens the input for the key input commands.  It returns nil if
+// the key input should not wait.  If the script is read from stdin, the
+// terminal is used for the key input.
+func openKeyInput(input string) (io.ReadCloser, error) {
+	switch {
+	case params.yes:
+		return nil, nil
+	case params.responses != "":
+		f, err := os.Open(params.responses)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open responses file: %w", err)
+		}
+		return f, nil
+	case input == "" || input == "-":
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			slog.Warn("no terminal for key input, key input commands will not wait", "error", err)
+			return nil, nil
+		}
+		return tty, nil
+	default:
+		return io.NopCloser(os.Stdin), nil
+	}
+}
+
+// renderer renders the decoded entries.
+type renderer interface {
+	// Render renders a single entry.
+	Render(entry senddat.Entry) error
+	// Close finishes rendering.
+	Close() error
+}
+
+// newRenderer returns the renderer for the format.
+func newRenderer(format string, w io.Writer) (renderer, error) {
+	switch format {
+	case "text", "":
+		return stringRenderer{w: w}, nil
+	case "dat":
+		return datRenderer{w: w}, nil
+	case "json":
+		return &jsonRenderer{w: w, enc: params.encoding}, nil
+	case "jsonl":
+		return jsonlRenderer{enc: json.NewEncoder(w), bytes: params.encoding}, nil
+	case "hexdump":
+		return hexdumpRenderer{w: w}, nil
+	case "png":
+		vp := senddat.NewVirtualPrinter(params.paperWidth)
+		if params.profile != nil && !isFlagSet("paper") {
+			vp = params.profile.VirtualPrinter()
+		}
+		return &pngRenderer{w: w, vp: vp}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %q", format)
+	}
+}
+
+// stringRenderer outputs entry descriptions, one per line.
+type stringRenderer struct {
+	w io.Writer
+}
+
+func (r stringRenderer) Render(entry senddat.Entry) error {
+	_, err := fmt.Fprintln(r.w, entry.String())
+	return err
+}
+
+func (stringRenderer) Close() error { return nil }
+
+// datRenderer outputs entries as senddat source, that can be edited and
+// parsed back.
+type datRenderer struct {
+	w io.Writer
+}
+
+func (r datRenderer) Render(entry senddat.Entry) error {
+	_, err := fmt.Fprintln(r.w, entry.Dat())
+	return err
+}
+
+func (datRenderer) Close() error { return nil }
+
+// jsonRenderer outputs entries as a JSON array.  Entries are written as they
+// are rendered, so that the output starts immediately.
+type jsonRenderer struct {
+	w     io.Writer
+	enc   senddat.ByteEncoding
+	count int
+}
+
+func (r *jsonRenderer) Render(entry senddat.Entry) error {
+	data, err := json.Marshal(entry.JSON(r.enc))
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if r.count == 0 {
+		sep = "[\n"
+	}
+	r.count++
+	_, err = fmt.Fprintf(r.w, "%s  %s", sep, data)
+	return err
+}
+
+func (r *jsonRenderer) Close() error {
+	if r.count == 0 {
+		_, err := fmt.Fprintln(r.w, "[]")
 		return err
 	}
+	_, err := fmt.Fprintln(r.w, "\n]")
+	return err
+}
+
+// jsonlRenderer outputs entries in JSON Lines format, one JSON object per
+// line.
+type jsonlRenderer struct {
+	enc   *json.Encoder
+	bytes senddat.ByteEncoding
+}
+
+func (r jsonlRenderer) Render(entry senddat.Entry) error {
+	return r.enc.Encode(entry.JSON(r.bytes))
+}
+
+func (jsonlRenderer) Close() error { return nil }
+
+// hexdumpRenderer outputs the annotated hex dump of entries.
+type hexdumpRenderer struct {
+	w io.Writer
+}
+
+func (r hexdumpRenderer) Render(entry senddat.Entry) error {
+	_, err := fmt.Fprintln(r.w, entry.HexDump())
+	return err
+}
+
+func (hexdumpRenderer) Close() error { return nil }
+
+// pngRenderer prints entries on the virtual printer and outputs the PNG
+// image of the paper roll.
+type pngRenderer struct {
+	w  io.Writer
+	vp *senddat.VirtualPrinter
+}
+
+func (r *pngRenderer) Render(entry senddat.Entry) error {
+	r.vp.Print(entry)
+	return nil
+}
+
+func (r *pngRenderer) Close() error {
+	return png.Encode(r.w, r.vp.Image())
 }
 
 func reverse(_ context.Context, input string, output string) error {
+	specs, err := commandSpecs()
+	if err != nil {
+		return err
+	}
 	r, w, err := openFiles(input, output)
 	if err != nil {
 		return fmt.Errorf("failed to open files: %w", err)
 	}
 	defer r.Close()
 	defer w.Close()
-	renderFn := stringRenderFn(w)
+	rndr, err := newRenderer(params.format, w)
+	if err != nil {
+		return err
+	}
 
-	entries, err := senddat.Decode(r, senddat.GenericCommandSpecs)
+	interp, err := senddat.NewInterpreter(r, specs)
 	if err != nil {
-		return fmt.Errorf("failed to decode input: %w", err)
+		return fmt.Errorf("failed to create interpreter: %w", err)
 	}
+	// senddat source and hex dump must show every byte of the input.
+	interp.Lossless = params.format == "dat" || params.format == "hexdump"
 
-	for _, entry := range entries {
-		if err := renderFn(entry); err != nil {
+	for entry, err := range interp.All() {
+		if err != nil {
+			return fmt.Errorf("failed to decode input: %w", err)
+		}
+		if params.profile != nil {
+			if err := params.profile.Check(entry); err != nil {
+				slog.Warn("unsupported command", "profile", params.profile.Name, "offset", entry.Offset, "error", err)
+			}
+		}
+		if err := rndr.Render(entry); err != nil {
 			return fmt.Errorf("failed to render entry: %w", err)
 		}
 	}
+	if err := rndr.Close(); err != nil {
+		return fmt.Errorf("failed to render output: %w", err)
+	}
 
 	slog.Info("Data reversed successfully", "output", output, "input", input)
 	return nil
 }
 
+// commandSpecs returns the command specs for the reverse mode: the driver
+// of -driver, or of the profile, or the generic one, with the functions of
+// -subcommands.
+func commandSpecs() ([]senddat.CommandSpec, error) {
+	var parseFn senddat.ParseFunc
+	switch params.prefixFmt {
+	case "expr", "":
+		parseFn = senddat.ParseString
+	case "hex":
+		parseFn = senddat.ParseHexBytes
+	default:
+		return nil, fmt.Errorf("unknown prefix format: %q, want expr or hex", params.prefixFmt)
+	}
+	specs := senddat.GenericCommandSpecs
+	switch {
+	case params.driver != "":
+		var err error
+		if specs, err = senddat.LoadDriverFunc(params.driver, parseFn); err != nil {
+			return nil, fmt.Errorf("failed to load driver: %w", err)
+		}
+	case params.profile != nil:
+		specs = params.profile.CommandSpecs()
+	}
+	if params.subCSV != "" {
+		var err error
+		if specs, err = senddat.WithSubcommands(specs, params.subCSV, parseFn); err != nil {
+			return nil, fmt.Errorf("failed to load subcommands: %w", err)
+		}
+	}
+	return specs, nil
+}
+
+// subcommand returns the subcommand named by the first argument.  The regular
+// file with the same name is the input, so that "senddat test" still sends
+// the file "test", if there is one; "senddat -- test" always does.
+func subcommand(args []string) (func(args []string) error, bool) {
+	if len(args) == 0 {
+		return nil, false
+	}
+	cmd, ok := subcommands[args[0]]
+	if !ok {
+		return nil, false
+	}
+	if fi, err := os.Stat(args[0]); err == nil && fi.Mode().IsRegular() {
+		slog.Debug("file exists, not running the subcommand", "name", args[0])
+		return nil, false
+	}
+	return cmd, true
+}
+
 func usage() {
 	out := flag.CommandLine.Output()
 	fmt.Fprintf(out, "Open Send Data Tool - parses ESC/POS dat files and sends data to a file or a printer.\n")
 	fmt.Fprintf(out, "It does the same as Epson ESC/POS senddat [1] utility, but can be compiled for\n")
 	fmt.Fprintf(out, "different platforms and architectures.")
 	fmt.Fprintf(out, "\t[1]: https://download.ebz.epson.net/dsc/du/02/DriverDownloadInfo.do?LG2=EN&CN2=US&CTI=381&PRN=TM-m30II&OSC=W1164\n\n")
-	fmt.Fprintf(out, "Usage: %s [-o <output>] [input]\n\n", os.Args[0])
+	fmt.Fprintf(out, "Usage: %s [-o <output>] [input]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s test [-update] [dir]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s status [-asb] <printer URI>\n", os.Args[0])
+	fmt.Fprintf(out, "       %s drivers\n\n", os.Args[0])
+	fmt.Fprintf(out, "The input file named as a subcommand is sent, if it exists.\n\n")
 	fmt.Fprintf(out, "Flags:\n")
 	flag.PrintDefaults()
 }
@@ -120,7 +555,9 @@ func usage() {
 func openFiles(input string, output string) (io.ReadCloser, io.WriteCloser, error) {
 	var r io.ReadCloser
 	if input == "" || input == "-" {
-		r = os.Stdin
+		// hide the name of stdin, so that includes are resolved against the
+		// current directory.
+		r = io.NopCloser(os.Stdin)
 	} else {
 		file, err := os.Open(input)
 		if err != nil {
@@ -132,9 +569,17 @@ func openFiles(input string, output string) (io.ReadCloser, io.WriteCloser, erro
 	var w io.WriteCloser
 	if output == "" || output == "-" {
 		w = os.Stdout
+	} else if senddat.IsTransportURI(output) {
+		t, err := senddat.OpenTransport(output, params.transport)
+		if err != nil {
+			r.Close()
+			return nil, nil, fmt.Errorf("failed to open printer: %w", err)
+		}
+		w = t
 	} else {
 		file, err := os.Create(output)
 		if err != nil {
+			r.Close()
 			return nil, nil, fmt.Errorf("failed to create output file: %w", err)
 		}
 		w = file
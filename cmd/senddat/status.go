@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/rusq/senddat"
+)
+
+// statusParams are the parameters of the "status" subcommand.
+type statusParams struct {
+	uri       string
+	timeout   time.Duration
+	asb       bool
+	json      bool
+	transport senddat.TransportOptions
+}
+
+// statusCmd queries the printer status, or, with -asb, enables the Automatic
+// Status Back and prints the status on every change until interrupted.
+func statusCmd(args []string) error {
+	var p statusParams
+	fset := flag.NewFlagSet("status", flag.ExitOnError)
+	fset.Usage = func() {
+		out := fset.Output()
+		fmt.Fprintf(out, "Queries the printer real-time status with DLE EOT.  With -asb, enables the\n")
+		fmt.Fprintf(out, "Automatic Status Back (GS a) and prints the status on every change until\n")
+		fmt.Fprintf(out, "interrupted.\n\n")
+		fmt.Fprintf(out, "Usage: %s status [flags] <printer URI>\n\nFlags:\n", os.Args[0])
+		fset.PrintDefaults()
+	}
+	fset.DurationVar(&p.timeout, "timeout", senddat.DefaultStatusTimeout, "status response `timeout`")
+	fset.BoolVar(&p.asb, "asb", false, "enable Automatic Status Back and watch the status changes")
+	fset.BoolVar(&p.json, "json", false, "print the status as JSON")
+	fset.DurationVar(&p.transport.ConnectTimeout, "connect-timeout", 10*time.Second, "printer connection `timeout`")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() != 1 {
+		fset.Usage()
+		return errors.New("printer URI is required")
+	}
+	p.uri = fset.Arg(0)
+
+	t, err := senddat.OpenTransport(p.uri, p.transport)
+	if err != nil {
+		return fmt.Errorf("failed to open printer: %w", err)
+	}
+	defer t.Close()
+	dt, ok := t.(senddat.DuplexTransport)
+	if !ok {
+		return fmt.Errorf("%s: transport can't receive the printer status", p.uri)
+	}
+
+	if !p.asb {
+		st, err := senddat.QueryStatus(dt, p.timeout)
+		if err != nil {
+			return err
+		}
+		return p.print(os.Stdout, st)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		// unblock the ASB read on interrupt.
+		<-ctx.Done()
+		dt.SetReadDeadline(time.Now())
+	}()
+	if err := senddat.EnableASB(dt, senddat.ASBAll); err != nil {
+		return fmt.Errorf("failed to enable ASB: %w", err)
+	}
+	defer senddat.EnableASB(dt, 0)
+	for {
+		st, err := senddat.ReadASB(dt, 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := p.print(os.Stdout, st); err != nil {
+			return err
+		}
+	}
+}
+
+func (p statusParams) print(w io.Writer, st senddat.Status) error {
+	if p.json {
+		return json.NewEncoder(w).Encode(st)
+	}
+	_, err := fmt.Fprintf(w, "%s\t%s\n", time.Now().Format(time.TimeOnly), st)
+	return err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rusq/senddat"
+)
+
+// testParams are the parameters of the "test" subcommand.
+type testParams struct {
+	dir        string
+	golden     string
+	update     bool
+	paperWidth int
+}
+
+// testCmd renders every .dat and .tmpl file under the directory and compares
+// the result with the golden PNG images.
+func testCmd(args []string) error {
+	var p testParams
+	fset := flag.NewFlagSet("test", flag.ExitOnError)
+	fset.Usage = func() {
+		out := fset.Output()
+		fmt.Fprintf(out, "Renders every .dat and .tmpl file under the directory on the virtual printer\n")
+		fmt.Fprintf(out, "and compares it with the golden PNG image.  On mismatch, writes the diff image\n")
+		fmt.Fprintf(out, "next to the golden image, with missing dots in blue, and extra dots in red.\n\n")
+		fmt.Fprintf(out, "Usage: %s test [flags] [dir]\n\nFlags:\n", os.Args[0])
+		fset.PrintDefaults()
+	}
+	fset.StringVar(&p.golden, "golden", "", "golden images `directory` (default <dir>/golden)")
+	fset.BoolVar(&p.update, "update", false, "update golden images")
+	fset.IntVar(&p.paperWidth, "paper", senddat.DefaultPaperWidth, "paper width in `dots`")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	p.dir = "."
+	if fset.NArg() > 0 {
+		p.dir = fset.Arg(0)
+	}
+	if p.golden == "" {
+		p.golden = filepath.Join(p.dir, "golden")
+	}
+
+	var files []string
+	err := filepath.WalkDir(p.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && filepath.Clean(path) == filepath.Clean(p.golden) {
+			return filepath.SkipDir
+		}
+		if ext := filepath.Ext(path); !d.IsDir() && (ext == ".dat" || ext == ".tmpl") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, file := range files {
+		result, err := p.check(file)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL\t%s: %v\n", file, err)
+			continue
+		}
+		fmt.Printf("%s\t%s\n", result, file)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(files))
+	}
+	return nil
+}
+
+// check renders the file and compares it with the golden image, or updates
+// the golden image.  It returns the short result description.
+func (p testParams) check(file string) (string, error) {
+	got, err := p.render(file)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(p.dir, file)
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Join(p.golden, strings.TrimSuffix(rel, filepath.Ext(rel)))
+	goldenFile, diffFile := base+".png", base+".diff.png"
+
+	if p.update {
+		_ = os.Remove(diffFile)
+		if err := writePNG(goldenFile, got); err != nil {
+			return "", err
+		}
+		return "updated", nil
+	}
+
+	want, err := readPNG(goldenFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("no golden image %s, run with -update to create", goldenFile)
+		}
+		return "", err
+	}
+	n, diff := senddat.DiffImages(want, got)
+	if n > 0 {
+		if err := writePNG(diffFile, diff); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("%d dots differ, see %s", n, diffFile)
+	}
+	_ = os.Remove(diffFile)
+	return "ok", nil
+}
+
+// render parses the file as a template and renders it on the virtual
+// printer.
+func (p testParams) render(file string) (image.Image, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var prn bytes.Buffer
+	// delays and key input are irrelevant for rendering.
+	parser := senddat.NewParser(senddat.Options{Output: io.Discard, Sleep: func(time.Duration) {}})
+	if err := parser.ParseFromTemplate(&prn, f); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	entries, err := senddat.Decode(&prn, senddat.GenericCommandSpecs)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return senddat.Render(entries, p.paperWidth), nil
+}
+
+func readPNG(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(filename string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
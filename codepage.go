@@ -0,0 +1,348 @@
+package senddat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/width"
+)
+
+// CodePage is the character code table of the printer.
+type CodePage struct {
+	// Name is the code page name, i.e. "PC866".
+	Name string
+	// Table is the table number n of ESC t n.
+	Table byte
+	// Aliases are the other names of the code page, i.e. "CP866".
+	Aliases []string
+
+	encode func(r rune) (byte, bool)
+	decode func(b byte) rune
+	// fold converts the string to the form that the code page has, if set.
+	fold func(s string) string
+}
+
+// fromCharmap returns the code page for the charmap.
+func fromCharmap(name string, table byte, cm *charmap.Charmap, aliases ...string) CodePage {
+	return CodePage{
+		Name:    name,
+		Table:   table,
+		Aliases: aliases,
+		encode:  cm.EncodeRune,
+		decode:  cm.DecodeByte,
+	}
+}
+
+// katakana is the JIS X 0201 half-width katakana table.  Full-width katakana
+// is folded to half-width before encoding.
+var katakana = CodePage{
+	Name:  "Katakana",
+	Table: 1,
+	encode: func(r rune) (byte, bool) {
+		if r >= 0xFF61 && r <= 0xFF9F {
+			return byte(r - 0xFF61 + 0xA1), true
+		}
+		return 0, false
+	},
+	decode: func(b byte) rune {
+		if b >= 0xA1 && b <= 0xDF {
+			return rune(b) - 0xA1 + 0xFF61
+		}
+		return utf8.RuneError
+	},
+	fold: width.Narrow.String,
+}
+
+// CodePages is the list of the code pages that the printer supports.
+type CodePages []CodePage
+
+// StandardCodePages are the code pages with the ESC t table numbers of the
+// Epson TM series printers.
+var StandardCodePages = CodePages{
+	fromCharmap("PC437", 0, charmap.CodePage437, "CP437", "437"),
+	katakana,
+	fromCharmap("PC850", 2, charmap.CodePage850, "CP850", "850"),
+	fromCharmap("PC860", 3, charmap.CodePage860, "CP860", "860"),
+	fromCharmap("PC863", 4, charmap.CodePage863, "CP863", "863"),
+	fromCharmap("PC865", 5, charmap.CodePage865, "CP865", "865"),
+	fromCharmap("ISO8859-7", 15, charmap.ISO8859_7, "Greek"),
+	fromCharmap("WPC1252", 16, charmap.Windows1252, "CP1252", "Windows-1252", "1252"),
+	fromCharmap("PC866", 17, charmap.CodePage866, "CP866", "866"),
+	fromCharmap("PC852", 18, charmap.CodePage852, "CP852", "852"),
+	fromCharmap("PC858", 19, charmap.CodePage858, "CP858", "858"),
+	fromCharmap("PC855", 34, charmap.CodePage855, "CP855", "855"),
+	fromCharmap("PC862", 36, charmap.CodePage862, "CP862", "862"),
+	fromCharmap("ISO8859-2", 39, charmap.ISO8859_2, "Latin2"),
+	fromCharmap("ISO8859-15", 40, charmap.ISO8859_15, "Latin9"),
+	fromCharmap("WPC1250", 45, charmap.Windows1250, "CP1250", "Windows-1250", "1250"),
+	fromCharmap("WPC1251", 46, charmap.Windows1251, "CP1251", "Windows-1251", "1251"),
+	fromCharmap("WPC1253", 47, charmap.Windows1253, "CP1253", "Windows-1253", "1253"),
+	fromCharmap("WPC1254", 48, charmap.Windows1254, "CP1254", "Windows-1254", "1254"),
+	fromCharmap("WPC1255", 49, charmap.Windows1255, "CP1255", "Windows-1255", "1255"),
+	fromCharmap("WPC1256", 50, charmap.Windows1256, "CP1256", "Windows-1256", "1256"),
+	fromCharmap("WPC1257", 51, charmap.Windows1257, "CP1257", "Windows-1257", "1257"),
+	fromCharmap("WPC1258", 52, charmap.Windows1258, "CP1258", "Windows-1258", "1258"),
+}
+
+// ErrUnknownCodePage is returned for the code page that is not in the list.
+var ErrUnknownCodePage = errors.New("unknown code page")
+
+// normCodePage normalises the code page name for the lookup.
+func normCodePage(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(name))
+}
+
+// Lookup returns the code page by its name or alias, case-insensitive.
+func (cps CodePages) Lookup(name string) (CodePage, error) {
+	key := normCodePage(name)
+	for _, cp := range cps {
+		if normCodePage(cp.Name) == key {
+			return cp, nil
+		}
+		for _, alias := range cp.Aliases {
+			if normCodePage(alias) == key {
+				return cp, nil
+			}
+		}
+	}
+	return CodePage{}, fmt.Errorf("%w: %s", ErrUnknownCodePage, name)
+}
+
+// Table returns the code page by its ESC t table number.
+func (cps CodePages) Table(n byte) (CodePage, bool) {
+	for _, cp := range cps {
+		if cp.Table == n {
+			return cp, true
+		}
+	}
+	return CodePage{}, false
+}
+
+// UnmappableError is returned when the code page does not have the
+// character.
+type UnmappableError struct {
+	Rune     rune
+	CodePage string
+}
+
+func (e *UnmappableError) Error() string {
+	return fmt.Sprintf("character %q (%U) is not in code page %s", e.Rune, e.Rune, e.CodePage)
+}
+
+// Encode converts the UTF-8 string to the code page bytes.  ASCII characters
+// are passed as is.  It returns [*UnmappableError] for the first character
+// that the code page does not have.
+func (cp CodePage) Encode(s string) ([]byte, error) {
+	if cp.fold != nil {
+		s = cp.fold(s)
+	}
+	buf := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			buf = append(buf, byte(r))
+			continue
+		}
+		b, ok := cp.encode(r)
+		if !ok {
+			return buf, &UnmappableError{Rune: r, CodePage: cp.Name}
+		}
+		buf = append(buf, b)
+	}
+	return buf, nil
+}
+
+// Decode returns the character of the byte in the code page.
+func (cp CodePage) Decode(b byte) rune {
+	if b < utf8.RuneSelf || cp.decode == nil {
+		return rune(b)
+	}
+	return cp.decode(b)
+}
+
+// AutoCodePage is the code page name that turns on the automatic code page
+// switching: each string literal is split into runs of characters, and each
+// run is output in the code page that has it, with ESC t n.
+const AutoCodePage = "auto"
+
+// codePageState tracks the code page selected on the printer, to transcode
+// the string literals.
+type codePageState struct {
+	pages   CodePages
+	enabled bool            // transcoding is enabled
+	auto    bool            // code page is switched automatically
+	current *CodePage       // nil if the selected table is unknown
+	table   byte            // selected table
+	cmds    *commandTracker // follows ESC t n and ESC @ in the output
+}
+
+// newCodePageState returns the state with the initial code page name, or
+// disabled transcoding if the name is empty.  The printer is assumed to have
+// the table 0 selected, unless the name is set.  The commands in the output
+// are recognised with specs.
+func newCodePageState(pages CodePages, name string, specs []CommandSpec) (*codePageState, error) {
+	st := &codePageState{pages: pages, cmds: newCommandTracker(specs)}
+	st.selectTable(0)
+	switch {
+	case name == "":
+	case normCodePage(name) == normCodePage(AutoCodePage):
+		st.setAuto()
+	default:
+		cp, err := pages.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		st.set(cp)
+	}
+	return st, nil
+}
+
+// set enables transcoding to the code page.
+func (st *codePageState) set(cp CodePage) {
+	st.enabled = true
+	st.auto = false
+	st.current = &cp
+	st.table = cp.Table
+}
+
+// setAuto enables the automatic code page switching.
+func (st *codePageState) setAuto() {
+	st.enabled = true
+	st.auto = true
+}
+
+// disable disables transcoding, strings are output in UTF-8.
+func (st *codePageState) disable() {
+	st.enabled = false
+	st.auto = false
+}
+
+// selectTable follows the ESC t n sent to the printer.
+func (st *codePageState) selectTable(n byte) {
+	st.table = n
+	if cp, ok := st.pages.Table(n); ok {
+		st.current = &cp
+	} else {
+		st.current = nil
+	}
+}
+
+// observe follows the ESC t n and ESC @ commands in the output.  The
+// arguments and payloads of other commands are skipped.
+func (st *codePageState) observe(p []byte) {
+	for _, b := range p {
+		cs, args := st.cmds.feed(b)
+		if cs == nil {
+			continue
+		}
+		switch string(cs.Prefix) {
+		case "\x1bt": // ESC t
+			if len(args) > 0 {
+				st.selectTable(args[0])
+			}
+		case "\x1b@": // ESC @
+			// ESC @ resets the printer to the table 0.
+			st.selectTable(0)
+		}
+	}
+}
+
+// Write observes the output p, so that the transcoding follows the bytes
+// that are written bypassing the parser, i.e. included files and images.
+func (st *codePageState) Write(p []byte) (int, error) {
+	st.observe(p)
+	return len(p), nil
+}
+
+// encode transcodes the string literal.
+func (st *codePageState) encode(s string) ([]byte, error) {
+	if !st.enabled {
+		return []byte(s), nil
+	}
+	if st.auto {
+		return st.encodeAuto(s)
+	}
+	if st.current == nil {
+		for _, r := range s {
+			if r >= utf8.RuneSelf {
+				return nil, fmt.Errorf("character %q: code table %d is not known", r, st.table)
+			}
+		}
+		return []byte(s), nil
+	}
+	return st.current.Encode(s)
+}
+
+// encodeAuto transcodes the string, switching the code page with ESC t n for
+// the runs of characters that the selected code page does not have.  The
+// selected table is restored after the string.
+func (st *codePageState) encodeAuto(s string) ([]byte, error) {
+	if st.current != nil {
+		if buf, err := st.current.Encode(s); err == nil {
+			return buf, nil
+		}
+	}
+	var (
+		buf   = make([]byte, 0, len(s))
+		cur   = st.current
+		runes = []rune(s)
+	)
+	for i := 0; i < len(runes); i++ {
+		if cur != nil {
+			if b, ok := cur.encodeRune(runes[i]); ok {
+				buf = append(buf, b...)
+				continue
+			}
+		}
+		cp, ok := st.pages.best(runes[i:])
+		if !ok {
+			return nil, fmt.Errorf("character %q (%U) is not in any of the code pages", runes[i], runes[i])
+		}
+		cur = cp
+		buf = append(buf, byte(bESC), 't', cp.Table)
+		b, _ := cur.encodeRune(runes[i])
+		buf = append(buf, b...)
+	}
+	if cur != st.current && (cur == nil || cur.Table != st.table) {
+		buf = append(buf, byte(bESC), 't', st.table)
+	}
+	return buf, nil
+}
+
+// encodeRune returns the bytes of a single character, if the code page has
+// it.
+func (cp *CodePage) encodeRune(r rune) ([]byte, bool) {
+	b, err := cp.Encode(string(r))
+	return b, err == nil
+}
+
+// best returns the code page that has the longest run of characters from the
+// start of runes, the first one in the list if there are several.  ASCII
+// characters are in every code page.
+func (cps CodePages) best(runes []rune) (*CodePage, bool) {
+	var (
+		best  *CodePage
+		bestN int
+	)
+	for i := range cps {
+		cp := &cps[i]
+		n := 0
+		for _, r := range runes {
+			if _, ok := cp.encodeRune(r); !ok {
+				break
+			}
+			n++
+		}
+		if n > bestN {
+			best, bestN = cp, n
+		}
+	}
+	return best, best != nil
+}
@@ -0,0 +1,288 @@
+package senddat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodePages_Lookup(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantTable byte
+		wantErr   bool
+	}{
+		{"PC866", 17, false},
+		{"cp866", 17, false},
+		{"866", 17, false},
+		{"windows-1251", 46, false},
+		{"WPC1252", 16, false},
+		{"pc_858", 19, false},
+		{"katakana", 1, false},
+		{"EBCDIC", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, err := StandardCodePages.Lookup(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Lookup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrUnknownCodePage)
+				return
+			}
+			assert.Equal(t, tt.wantTable, cp.Table)
+		})
+	}
+}
+
+func TestCodePage_Encode(t *testing.T) {
+	tests := []struct {
+		name     string
+		codePage string
+		s        string
+		want     []byte
+		wantErr  bool
+	}{
+		{"ascii", "PC437", "Hi!", []byte("Hi!"), false},
+		{"cyrillic", "PC866", "Привет", []byte{0x8F, 0xE0, 0xA8, 0xA2, 0xA5, 0xE2}, false},
+		{"cyrillic windows", "WPC1251", "Да", []byte{0xC4, 0xE0}, false},
+		{"euro", "PC858", "5€", []byte{'5', 0xD5}, false},
+		{"half-width katakana", "Katakana", "ｱｲ", []byte{0xB1, 0xB2}, false},
+		{"full-width katakana", "Katakana", "アイ", []byte{0xB1, 0xB2}, false},
+		{"unmappable", "PC437", "Привет", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, err := StandardCodePages.Lookup(tt.codePage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cp.Encode(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				var ue *UnmappableError
+				if assert.True(t, errors.As(err, &ue)) {
+					assert.Equal(t, 'П', ue.Rune)
+				}
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCodePage_Decode(t *testing.T) {
+	cp, err := StandardCodePages.Lookup("PC866")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 'A', cp.Decode('A'))
+	assert.Equal(t, 'П', cp.Decode(0x8F))
+	assert.Equal(t, rune(0xE9), CodePage{}.Decode(0xE9))
+}
+
+func TestParse_codePage(t *testing.T) {
+	tests := []struct {
+		name     string
+		codePage string
+		src      string
+		want     []byte
+		wantErr  string
+	}{
+		{
+			name: "utf-8 by default",
+			src:  `"Да"`,
+			want: []byte("Да"),
+		},
+		{
+			name:     "option",
+			codePage: "PC866",
+			src:      `"Да"`,
+			want:     []byte{0x84, 0xA0},
+		},
+		{
+			name: "directive",
+			src:  "%PC866\n\"Да\"",
+			want: []byte{0x1B, 't', 17, 0x84, 0xA0},
+		},
+		{
+			name: "directive disables",
+			src:  "%PC866\n%utf8\n\"Да\"",
+			want: append([]byte{0x1B, 't', 17}, "Да"...),
+		},
+		{
+			name:     "follows ESC t",
+			codePage: "PC866",
+			src:      "ESC \"t\" 46 `Да`",
+			want:     []byte{0x1B, 't', 46, 0xC4, 0xE0},
+		},
+		{
+			name:     "ESC @ resets to the table 0",
+			codePage: "PC866",
+			src:      `ESC "@" "Ä"`,
+			want:     []byte{0x1B, '@', 0x8E},
+		},
+		{
+			name:     "ESC @ in arguments",
+			codePage: "PC866",
+			src:      `GS "!" 0x1B "@shop" "Да"`,
+			want:     append([]byte{0x1D, '!', 0x1B}, '@', 's', 'h', 'o', 'p', 0x84, 0xA0),
+		},
+		{
+			name:     "ESC t in payload",
+			codePage: "PC866",
+			src:      `ESC "*" 0 3 0 0x1B "t" 0 "Да"`,
+			want:     []byte{0x1B, '*', 0, 3, 0, 0x1B, 't', 0, 0x84, 0xA0},
+		},
+		{
+			name:     "unmappable",
+			codePage: "PC866",
+			src:      "\n\"Ä\"",
+			wantErr:  `character 'Ä' (U+00C4) is not in code page PC866 at line 2`,
+		},
+		{
+			name:     "unknown table",
+			codePage: "PC866",
+			src:      `ESC "t" 99 "Да"`,
+			wantErr:  "code table 99 is not known",
+		},
+		{
+			name:    "unknown code page",
+			src:     "%EBCDIC\n",
+			wantErr: "unknown code page: EBCDIC",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(Options{Output: testParser.opts.Output, CodePage: tt.codePage})
+			got, err := p.ParseString(tt.src)
+			if tt.wantErr != "" {
+				if assert.Error(t, err) {
+					assert.True(t, strings.Contains(err.Error(), tt.wantErr), err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParse_autoCodePage(t *testing.T) {
+	tests := []struct {
+		name     string
+		codePage string
+		src      string
+		want     []byte
+		wantErr  string
+	}{
+		{
+			name:     "ascii",
+			codePage: AutoCodePage,
+			src:      `"Milk"`,
+			want:     []byte("Milk"),
+		},
+		{
+			name:     "selected table has it",
+			codePage: AutoCodePage,
+			src:      `"Çay"`,
+			want:     []byte{0x80, 'a', 'y'},
+		},
+		{
+			name:     "mixed scripts",
+			codePage: AutoCodePage,
+			src:      `"Milk Молоко Γάλα"`,
+			want: []byte{
+				'M', 'i', 'l', 'k', ' ',
+				0x1B, 't', 17, 0x8C, 0xAE, 0xAB, 0xAE, 0xAA, 0xAE, ' ',
+				0x1B, 't', 15, 0xC3, 0xDC, 0xEB, 0xE1,
+				0x1B, 't', 0,
+			},
+		},
+		{
+			name: "directive keeps the selected table",
+			src:  "ESC \"t\" 17\n%auto\n\"Да λ\"",
+			want: []byte{0x1B, 't', 17, 0x84, 0xA0, ' ', 0x1B, 't', 15, 0xEB, 0x1B, 't', 17},
+		},
+		{
+			name:     "selected table is restored after the switch",
+			codePage: AutoCodePage,
+			src:      `"Да" "Ä"`,
+			want:     []byte{0x1B, 't', 17, 0x84, 0xA0, 0x1B, 't', 0, 0x8E},
+		},
+		{
+			name:     "not in any code page",
+			codePage: AutoCodePage,
+			src:      `"日本"`,
+			wantErr:  "character '日' (U+65E5) is not in any of the code pages",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(Options{Output: testParser.opts.Output, CodePage: tt.codePage})
+			got, err := p.ParseString(tt.src)
+			if tt.wantErr != "" {
+				if assert.Error(t, err) {
+					assert.True(t, strings.Contains(err.Error(), tt.wantErr), err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCodePages_best(t *testing.T) {
+	pages := CodePages{StandardCodePages[0]}
+	if _, ok := pages.best([]rune("Да")); ok {
+		t.Error("best() found the code page for Cyrillic in PC437")
+	}
+	cp, ok := StandardCodePages.best([]rune("Дaд"))
+	if assert.True(t, ok) {
+		assert.Equal(t, "PC866", cp.Name)
+	}
+}
+
+func TestParse_codePageInclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pc866.prn": {Data: []byte{0x1B, 't', 17}},
+		"reset.prn": {Data: []byte{0x1B, '*', 0, 2, 0, 0x1B, '@', 0x1B, '@'}},
+	}
+	tests := []struct {
+		name string
+		src  string
+		want []byte
+	}{
+		{
+			name: "raw include selects the table",
+			src:  "%PC437\n@pc866.prn\n\"Да\"",
+			want: []byte{0x1B, 't', 0, 0x1B, 't', 17, 0x84, 0xA0},
+		},
+		{
+			name: "raw include resets the printer",
+			src:  "%PC866\n@reset.prn\n\"Ä\"",
+			want: []byte{0x1B, 't', 17, 0x1B, '*', 0, 2, 0, 0x1B, '@', 0x1B, '@', 0x8E},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(Options{Output: testParser.opts.Output, FS: fsys})
+			got, err := p.ParseString(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -2,32 +2,95 @@ package senddat
 
 import (
 	"bytes"
-	_ "embed"
+	"embed"
 	"encoding/csv"
 	"errors"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
 	"io"
+	"io/fs"
+	"maps"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-//go:embed drivers/xprinter.csv
-var genericcsv []byte
+//go:embed drivers/*.csv drivers/subcommands/*.csv
+var driverFS embed.FS
+
+// escposFunctions are the functions of the ESC/POS commands, such as
+// GS ( k, that are attached to the embedded and loaded drivers.
+var escposFunctions = sync.OnceValues(func() (map[string]map[string]*Subcommand, error) {
+	data, err := driverFS.ReadFile("drivers/subcommands/escpos.csv")
+	if err != nil {
+		return nil, err
+	}
+	return readSubcommands(bytes.NewReader(data), ParseString)
+})
 
 var GenericCommandSpecs []CommandSpec
 
 func init() {
 	var err error
-	GenericCommandSpecs, err = readCommandSpecs(bytes.NewReader(genericcsv), ParseFn)
+	GenericCommandSpecs, err = LoadDriver("xprinter")
 	if err != nil {
 		panic(fmt.Sprintf("failed to load generic command specs: %v", err))
 	}
 }
 
+// Drivers returns the names of the embedded drivers.
+func Drivers() []string {
+	files, _ := fs.Glob(driverFS, "drivers/*.csv")
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = strings.TrimSuffix(path.Base(file), ".csv")
+	}
+	slices.Sort(names)
+	return names
+}
+
+// LoadDriver loads the command specs of the embedded driver by its name, i.e.
+// "xprinter", or from the CSV file, if the name has the .csv extension.  The
+// functions of the ESC/POS commands, such as GS ( k, are attached to the
+// commands.
+func LoadDriver(name string) ([]CommandSpec, error) {
+	return LoadDriverFunc(name, ParseString)
+}
+
+// LoadDriverFunc is [LoadDriver] that parses the command prefixes of the CSV
+// file with parseFn.  The embedded drivers are always parsed with
+// [ParseString].
+func LoadDriverFunc(name string, parseFn ParseFunc) ([]CommandSpec, error) {
+	var (
+		specs []CommandSpec
+		err   error
+	)
+	if filepath.Ext(name) == ".csv" {
+		specs, err = loadCommandSpecs(name, parseFn)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := driverFS.ReadFile(path.Join("drivers", name+".csv"))
+		if err != nil {
+			return nil, fmt.Errorf("unknown driver: %s, have: %s", name, strings.Join(Drivers(), ", "))
+		}
+		specs, err = readCommandSpecs(bytes.NewReader(data), ParseString)
+		if err != nil {
+			return nil, fmt.Errorf("driver %s: %w", name, err)
+		}
+	}
+	funcs, err := escposFunctions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the ESC/POS functions: %w", err)
+	}
+	attachSubcommands(specs, funcs)
+	return specs, nil
+}
+
 type CommandSpec struct {
 	Prefix   []byte
 	Name     string
@@ -36,14 +99,14 @@ type CommandSpec struct {
 	// Ignore indicates that this command should be ignored during processing.
 	// Possibly, read to the next known command.
 	Ignore bool
-	// Caveat: This organisation supports only a payload that can be expressed
-	// with the formula.  It would fail on commands, that have variable payload,
-	// such as payload that terminates with a NUL, or a payload that instructs
-	// to read a number of bytes from the input stream, such as character
-	// redefinition.
-	// TODO: ingore flag in CSV to ignore certain commands.
-	payloadFn   func(args []byte) (int, error)
-	subcommands map[string]string // key: hex string of subcommand bytes
+	// payloadFn returns the payload size for the payload that can be
+	// expressed with the formula.
+	payloadFn func(args []byte) (int, error)
+	// readPayload reads the variable-length payload, that terminates with a
+	// byte, or consists of the items with their own lengths, such as
+	// character redefinition.
+	readPayload func(r payloadReader, args []byte) ([]byte, error)
+	subcommands map[string]*Subcommand // key: hex string of the selector bytes
 }
 
 func (cs CommandSpec) String() string {
@@ -72,42 +135,67 @@ func (cs CommandSpec) ArgValues(args []byte) (map[string]uint8, error) {
 	return argValues, nil
 }
 
+// LoadCommandSpecsWithSubcommands loads the command specs from the command
+// CSV, and the functions of the commands from the subcommands CSV.
 func LoadCommandSpecsWithSubcommands(cmdCSV, subCSV string) ([]CommandSpec, error) {
-	cmds, err := loadCommandSpecs(cmdCSV)
+	cmds, err := loadCommandSpecs(cmdCSV, ParseString)
 	if err != nil {
 		return nil, err
 	}
 
-	subMap, err := loadSubcommands(subCSV)
+	subMap, err := loadSubcommands(subCSV, ParseString)
 	if err != nil {
 		return nil, err
 	}
+	attachSubcommands(cmds, subMap)
+
+	return cmds, nil
+}
 
-	for i := range cmds {
-		key := hexKey(cmds[i].Prefix)
+// WithSubcommands returns the copy of specs with the functions from the
+// subcommands CSV attached, the command prefixes of the CSV are parsed with
+// parseFn.  The functions override the ones that the commands already have.
+func WithSubcommands(specs []CommandSpec, subCSV string, parseFn ParseFunc) ([]CommandSpec, error) {
+	subMap, err := loadSubcommands(subCSV, parseFn)
+	if err != nil {
+		return nil, err
+	}
+	specs = slices.Clone(specs)
+	for i := range specs {
+		// do not modify the functions of the original specs.
+		specs[i].subcommands = maps.Clone(specs[i].subcommands)
+	}
+	attachSubcommands(specs, subMap)
+	return specs, nil
+}
+
+// attachSubcommands sets the functions of the commands.  Functions of the
+// commands that are not in the specs are ignored.
+func attachSubcommands(specs []CommandSpec, subMap map[string]map[string]*Subcommand) {
+	for i := range specs {
+		key := hexKey(specs[i].Prefix)
 		if subs, ok := subMap[key]; ok {
-			cmds[i].subcommands = subs
+			if specs[i].subcommands == nil {
+				specs[i].subcommands = make(map[string]*Subcommand, len(subs))
+			}
+			maps.Copy(specs[i].subcommands, subs)
 		}
 	}
-
-	return cmds, nil
 }
 
-type ParseFunc func(s string) ([]byte, error)
-
-// ParseFn is the default parsing function for commands.  There are two
-// currently to choose from:
+// ParseFunc parses the command prefix in the CSV.  There are two currently
+// to choose from:
 //  1. ParseString - parses expressions like `ESC "@"'
-//  2. parseHexBytes - parses hex bytes, i.e. `1B 40'
-var ParseFn ParseFunc = ParseString
+//  2. ParseHexBytes - parses hex bytes, i.e. `1B 40'
+type ParseFunc func(s string) ([]byte, error)
 
-func loadCommandSpecs(csvPath string) ([]CommandSpec, error) {
+func loadCommandSpecs(csvPath string, parseFn ParseFunc) ([]CommandSpec, error) {
 	f, err := os.Open(csvPath)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	return readCommandSpecs(f, ParseString)
+	return readCommandSpecs(f, parseFn)
 }
 
 func readCommandSpecs(r io.Reader, parseFn ParseFunc) ([]CommandSpec, error) {
@@ -127,6 +215,8 @@ func readCommandSpecs(r io.Reader, parseFn ParseFunc) ([]CommandSpec, error) {
 			return nil, err
 		}
 
+		line, _ := cr.FieldPos(0)
+
 		// header is expected to be: "prefix,name,arg_names,payload_formula"
 		rowMap := map[string]string{}
 		for i, key := range header {
@@ -135,7 +225,7 @@ func readCommandSpecs(r io.Reader, parseFn ParseFunc) ([]CommandSpec, error) {
 
 		prefix, err := parseFn(rowMap["prefix"])
 		if err != nil {
-			return nil, fmt.Errorf("prefix %q: %v", rowMap["prefix"], err)
+			return nil, fmt.Errorf("line %d: prefix %q: %v", line, rowMap["prefix"], err)
 		}
 
 		argNames := strings.Split(rowMap["arg_names"], " ")
@@ -148,48 +238,78 @@ func readCommandSpecs(r io.Reader, parseFn ParseFunc) ([]CommandSpec, error) {
 		if ok && sIgnore != "" {
 			ignore, err = strconv.ParseBool(sIgnore)
 			if err != nil {
-				return nil, fmt.Errorf("invalid ignore value %q: %v", sIgnore, err)
+				return nil, fmt.Errorf("line %d: invalid ignore value %q: %v", line, sIgnore, err)
 			}
 		}
 
-		payloadFn, err := makePayloadFn(rowMap["payload_formula"], argNames)
-		if err != nil {
-			return nil, fmt.Errorf("payload fn for %x: %v", prefix, err)
+		spec := CommandSpec{
+			Prefix:   prefix,
+			Name:     rowMap["name"],
+			Ignore:   ignore,
+			ArgCount: len(argNames),
+			ArgNames: argNames,
 		}
-
-		specs = append(specs, CommandSpec{
-			Prefix:    prefix,
-			Name:      rowMap["name"],
-			Ignore:    ignore,
-			ArgCount:  len(argNames),
-			ArgNames:  argNames,
-			payloadFn: payloadFn,
-		})
+		if err := spec.setPayload(rowMap["payload_formula"]); err != nil {
+			return nil, fmt.Errorf("line %d: payload fn for %s: %v", line, rowMap["prefix"], err)
+		}
+		specs = append(specs, spec)
 	}
 
 	return specs, nil
 }
 
-// TODO: finish with subcommands.
-func loadSubcommands(csvPath string) (map[string]map[string]string, error) {
+func loadSubcommands(csvPath string, parseFn ParseFunc) (map[string]map[string]*Subcommand, error) {
 	f, err := os.Open(csvPath)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	return readSubcommands(f, ParseFn)
+	return readSubcommands(f, parseFn)
 }
 
-type SubCommands map[string]Subcommand
-
+// Subcommand is the function of the command, such as GS ( k pL pH cn fn,
+// that is selected by the first bytes of the payload.
 type Subcommand struct {
-	Prefix   string
-	Cn       byte
-	Fn       byte
-	Argcount string
+	// Selector is the cn fn, or fn, bytes at the start of the payload.
+	Selector []byte
+	// Name is the function name.
+	Name string
+	// ArgNames are the names of the function parameters that follow the
+	// selector.
+	ArgNames []string
+}
+
+// ArgValues returns the function parameters from the payload of the command.
+// The parameters that the payload does not have are omitted.
+func (sc *Subcommand) ArgValues(payload []byte) map[string]uint8 {
+	params := payload[min(len(sc.Selector), len(payload)):]
+	values := make(map[string]uint8, len(sc.ArgNames))
+	for i, name := range sc.ArgNames {
+		if i >= len(params) {
+			break
+		}
+		values[name] = params[i]
+	}
+	return values
 }
 
-func readSubcommands(f io.Reader, parseFn ParseFunc) (map[string]map[string]string, error) {
+// subcommand returns the function of the command selected by the payload, or
+// nil.
+func (cs *CommandSpec) subcommand(payload []byte) *Subcommand {
+	for n := min(2, len(payload)); n > 0; n-- {
+		if sc, ok := cs.subcommands[hexKey(payload[:n])]; ok {
+			return sc
+		}
+	}
+	return nil
+}
+
+// readSubcommands reads the functions of the commands.  The header is expected
+// to be "prefix,cn,fn,fn_name,fn_args", where the prefix is the command, i.e.
+// GS "(k", cn is the optional function group byte, and fn is the function
+// byte, as integers.  It returns the functions by the hex key of the command
+// prefix, and then by the hex key of the selector.
+func readSubcommands(f io.Reader, parseFn ParseFunc) (map[string]map[string]*Subcommand, error) {
 	r := csv.NewReader(f)
 	r.TrimLeadingSpace = true
 	header, err := r.Read()
@@ -197,7 +317,7 @@ func readSubcommands(f io.Reader, parseFn ParseFunc) (map[string]map[string]stri
 		return nil, err
 	}
 
-	result := make(map[string]map[string]string)
+	result := make(map[string]map[string]*Subcommand)
 
 	for {
 		row, err := r.Read()
@@ -206,26 +326,42 @@ func readSubcommands(f io.Reader, parseFn ParseFunc) (map[string]map[string]stri
 		} else if err != nil {
 			return nil, err
 		}
+		line, _ := r.FieldPos(0)
 
 		rowMap := make(map[string]string)
 		for i, key := range header {
 			rowMap[key] = row[i]
 		}
 
-		prefixBytes, err := parseFn(rowMap["prefix"])
-		if err != nil || len(prefixBytes) < 2 {
-			return nil, fmt.Errorf("invalid subcommand prefix: %v", err)
+		prefix, err := parseFn(rowMap["prefix"])
+		if err != nil || len(prefix) == 0 {
+			return nil, fmt.Errorf("line %d: invalid subcommand prefix %q: %v", line, rowMap["prefix"], err)
 		}
-
-		// Split into parent command and subcommand part
-		for cut := len(prefixBytes) - 1; cut >= 1; cut-- {
-			parentKey := hexKey(prefixBytes[:cut])
-			subKey := hexKey(prefixBytes[cut:])
-			if _, exists := result[parentKey]; !exists {
-				result[parentKey] = make(map[string]string)
+		var selector []byte
+		for _, col := range []string{"cn", "fn"} {
+			v := strings.TrimSpace(rowMap[col])
+			if v == "" && col == "cn" {
+				continue
 			}
-			result[parentKey][subKey] = rowMap["name"]
-			break
+			b, err := atob(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q: %v", line, col, v, err)
+			}
+			selector = append(selector, b)
+		}
+		argNames := strings.Fields(rowMap["fn_args"])
+		if argNames == nil {
+			argNames = []string{}
+		}
+
+		parentKey := hexKey(prefix)
+		if _, exists := result[parentKey]; !exists {
+			result[parentKey] = make(map[string]*Subcommand)
+		}
+		result[parentKey][hexKey(selector)] = &Subcommand{
+			Selector: selector,
+			Name:     rowMap["fn_name"],
+			ArgNames: argNames,
 		}
 	}
 
@@ -242,7 +378,6 @@ func hexKey(b []byte) string {
 
 // ParseHexBytes is an alternative parser for command prefixes, which expects a
 // string of hex bytes separated by spaces, e.g. "1B 40" for ESC @.
-// TODO: wire it to CLI flags.
 func ParseHexBytes(s string) ([]byte, error) {
 	parts := strings.Fields(s)
 	result := make([]byte, len(parts))
@@ -256,72 +391,270 @@ func ParseHexBytes(s string) ([]byte, error) {
 	return result, nil
 }
 
-func makePayloadFn(exprStr string, argNames []string) (func([]byte) (int, error), error) {
-	exprStr = strings.TrimSpace(exprStr)
-	if exprStr == "" {
-		return nil, nil
+// maxVarPayload is the maximum size of the variable-length payload, to stop
+// on the garbage in the stream.
+const maxVarPayload = 1 << 20
+
+// payloadReader reads the payload from the stream.
+type payloadReader interface {
+	readByte() (byte, error)
+	readBytes(n int) ([]byte, error)
+	// readUntil reads up to and including the terminator, at most
+	// maxVarPayload bytes.
+	readUntil(term byte) ([]byte, error)
+}
+
+// setPayload sets the payload of the command from the payload column of the
+// CSV, which is one of:
+//   - formula of the arguments, i.e. "nL+nH*256", the payload size;
+//   - "until:XX", the payload up to and including the byte XX (hex), i.e.
+//     "until:00" for the NUL-terminated list of ESC D;
+//   - "repeat(COUNT, NAMES: FORMULA)", COUNT items, each item has the bytes
+//     NAMES, followed by FORMULA bytes of data, i.e. for ESC & y c1 c2:
+//     "repeat(c2-c1+1, x: y*x)".  FORMULA may use the command arguments.
+//     The item may also be "until:XX", i.e. "repeat(5, until:3B)" for the
+//     five fields of GS C ; that end with ";";
+//   - "if(COND, THEN, ELSE)", THEN if the formula COND is not zero, otherwise
+//     ELSE, where THEN and ELSE are any of the above, i.e. for GS k m:
+//     "if(m < 65, until:00, repeat(1, n: n))".
+func (cs *CommandSpec) setPayload(s string) error {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "until:"):
+		fn, err := makeUntilFn(strings.TrimPrefix(s, "until:"))
+		if err != nil {
+			return err
+		}
+		cs.readPayload = fn
+	case strings.HasPrefix(s, "repeat(") && strings.HasSuffix(s, ")"):
+		fn, err := makeRepeatFn(s[len("repeat("):len(s)-1], cs.ArgNames)
+		if err != nil {
+			return err
+		}
+		cs.readPayload = fn
+	case strings.HasPrefix(s, "if(") && strings.HasSuffix(s, ")"):
+		fn, err := makeIfFn(s[len("if("):len(s)-1], cs.ArgNames)
+		if err != nil {
+			return err
+		}
+		cs.readPayload = fn
+	default:
+		fn, err := makePayloadFn(s, cs.ArgNames)
+		if err != nil {
+			return err
+		}
+		cs.payloadFn = fn
 	}
+	return nil
+}
 
-	node, err := parser.ParseExpr(exprStr)
+// makeUntilFn returns the function that reads the payload up to and
+// including the terminator byte, given in hex.
+func makeUntilFn(term string) (func(payloadReader, []byte) ([]byte, error), error) {
+	t, err := strconv.ParseUint(strings.TrimSpace(term), 16, 8)
 	if err != nil {
-		return nil, fmt.Errorf("invalid formula: %w", err)
+		return nil, fmt.Errorf("invalid terminator %q: %w", term, err)
 	}
+	return func(r payloadReader, _ []byte) ([]byte, error) {
+		return r.readUntil(byte(t))
+	}, nil
+}
 
-	return func(args []byte) (int, error) {
-		if len(args) != len(argNames) {
-			return -1, fmt.Errorf("number of arguments %d != number of argument names %d", len(args), len(argNames))
+// makeRepeatFn returns the function that reads the repeated items, the
+// definition is "COUNT, NAMES: FORMULA" or "COUNT, until:XX".
+func makeRepeatFn(def string, argNames []string) (func(payloadReader, []byte) ([]byte, error), error) {
+	countExpr, item, ok := cutTopLevel(def, ',')
+	if !ok {
+		return nil, fmt.Errorf("invalid repeat %q, want repeat(COUNT, NAMES: FORMULA)", def)
+	}
+	if term, ok := strings.CutPrefix(strings.TrimSpace(item), "until:"); ok {
+		return makeRepeatUntilFn(countExpr, term, argNames)
+	}
+	names, itemExpr, ok := strings.Cut(item, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid repeat item %q, want NAMES: FORMULA", item)
+	}
+	itemNames := strings.Fields(names)
+	countFn, err := makePayloadFn(countExpr, argNames)
+	if err != nil {
+		return nil, fmt.Errorf("repeat count: %w", err)
+	}
+	itemFn, err := makePayloadFn(itemExpr, append(slices.Clone(argNames), itemNames...))
+	if err != nil {
+		return nil, fmt.Errorf("repeat item: %w", err)
+	}
+	if countFn == nil || itemFn == nil {
+		return nil, fmt.Errorf("invalid repeat %q: empty formula", def)
+	}
+	return func(r payloadReader, args []byte) ([]byte, error) {
+		count, err := countFn(args)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("invalid repeat count: %d", count)
 		}
-		env := map[string]int{}
-		for i, name := range argNames {
-			if i < len(args) {
-				env[name] = int(args[i])
+		var buf []byte
+		for range count {
+			hdr, err := r.readBytes(len(itemNames))
+			if err != nil {
+				return nil, err
 			}
+			n, err := itemFn(append(slices.Clone(args), hdr...))
+			if err != nil {
+				return nil, err
+			}
+			if len(buf)+len(hdr)+n > maxVarPayload {
+				return nil, fmt.Errorf("payload is larger than %d bytes", maxVarPayload)
+			}
+			data, err := r.readBytes(n)
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, hdr...)
+			buf = append(buf, data...)
 		}
-		return evalExpr(node, env)
+		return buf, nil
 	}, nil
 }
 
-func evalExpr(node ast.Expr, vars map[string]int) (int, error) {
-	switch e := node.(type) {
-	case *ast.BinaryExpr:
-		left, err := evalExpr(e.X, vars)
+// makeIfFn returns the function that reads the payload of one of the two
+// branches, the definition is "COND, THEN, ELSE".
+func makeIfFn(def string, argNames []string) (func(payloadReader, []byte) ([]byte, error), error) {
+	condExpr, rest, ok := cutTopLevel(def, ',')
+	if !ok {
+		return nil, fmt.Errorf("invalid if %q, want if(COND, THEN, ELSE)", def)
+	}
+	thenDef, elseDef, ok := cutTopLevel(rest, ',')
+	if !ok {
+		return nil, fmt.Errorf("invalid if %q, want if(COND, THEN, ELSE)", def)
+	}
+	condFn, err := makePayloadFn(condExpr, argNames)
+	if err != nil {
+		return nil, fmt.Errorf("if condition: %w", err)
+	}
+	if condFn == nil {
+		return nil, fmt.Errorf("invalid if %q: empty condition", def)
+	}
+	var branches [2]func(payloadReader, []byte) ([]byte, error)
+	for i, bdef := range []string{thenDef, elseDef} {
+		b := CommandSpec{ArgNames: argNames}
+		if err := b.setPayload(bdef); err != nil {
+			return nil, fmt.Errorf("if branch %q: %w", strings.TrimSpace(bdef), err)
+		}
+		branches[i] = b.payloadReaderFn()
+	}
+	return func(r payloadReader, args []byte) ([]byte, error) {
+		cond, err := condFn(args)
 		if err != nil {
-			return 0, err
+			return nil, err
+		}
+		if cond != 0 {
+			return branches[0](r, args)
 		}
-		right, err := evalExpr(e.Y, vars)
+		return branches[1](r, args)
+	}, nil
+}
+
+// payloadReaderFn returns the function that reads the payload of the
+// command, whether it is given by the formula or read by readPayload.
+func (cs *CommandSpec) payloadReaderFn() func(payloadReader, []byte) ([]byte, error) {
+	if cs.readPayload != nil {
+		return cs.readPayload
+	}
+	return func(r payloadReader, args []byte) ([]byte, error) {
+		if cs.payloadFn == nil {
+			return nil, nil
+		}
+		n, err := cs.payloadFn(args)
 		if err != nil {
-			return 0, err
-		}
-		switch e.Op {
-		case token.ADD:
-			return left + right, nil
-		case token.MUL:
-			return left * right, nil
-		case token.SUB:
-			return left - right, nil
-		case token.QUO:
-			if right == 0 {
-				return 0, errors.New("division by zero")
-			}
-			return left / right, nil
-		default:
-			return 0, fmt.Errorf("unsupported op: %s", e.Op)
+			return nil, err
+		}
+		if n > maxVarPayload {
+			return nil, fmt.Errorf("payload is larger than %d bytes", maxVarPayload)
 		}
+		return r.readBytes(n)
+	}
+}
 
-	case *ast.Ident:
-		val, ok := vars[e.Name]
-		if !ok {
-			return 0, fmt.Errorf("unknown variable: %s", e.Name)
+// makeRepeatUntilFn returns the function that reads COUNT items, each
+// terminated with the byte, given in hex.
+func makeRepeatUntilFn(countExpr, term string, argNames []string) (func(payloadReader, []byte) ([]byte, error), error) {
+	countFn, err := makePayloadFn(countExpr, argNames)
+	if err != nil {
+		return nil, fmt.Errorf("repeat count: %w", err)
+	}
+	if countFn == nil {
+		return nil, errors.New("invalid repeat: empty count")
+	}
+	untilFn, err := makeUntilFn(term)
+	if err != nil {
+		return nil, fmt.Errorf("repeat item: %w", err)
+	}
+	return func(r payloadReader, args []byte) ([]byte, error) {
+		count, err := countFn(args)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("invalid repeat count: %d", count)
 		}
-		return val, nil
+		var buf []byte
+		for range count {
+			item, err := untilFn(r, args)
+			if err != nil {
+				return nil, err
+			}
+			if len(buf)+len(item) > maxVarPayload {
+				return nil, fmt.Errorf("payload is larger than %d bytes", maxVarPayload)
+			}
+			buf = append(buf, item...)
+		}
+		return buf, nil
+	}, nil
+}
 
-	case *ast.BasicLit:
-		return strconv.Atoi(e.Value)
+// cutTopLevel slices s around the first sep outside of the parentheses.
+func cutTopLevel(s string, sep byte) (before, after string, found bool) {
+	var depth int
+	for i := range len(s) {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				return s[:i], s[i+1:], true
+			}
+		}
+	}
+	return s, "", false
+}
 
-	case *ast.ParenExpr:
-		return evalExpr(e.X, vars)
+func makePayloadFn(exprStr string, argNames []string) (func([]byte) (int, error), error) {
+	exprStr = strings.TrimSpace(exprStr)
+	if exprStr == "" {
+		return nil, nil
+	}
 
-	default:
-		return 0, fmt.Errorf("unsupported expression: %T", e)
+	f, err := compileFormula(exprStr, argNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid formula %q: %w", exprStr, err)
 	}
+
+	return func(args []byte) (int, error) {
+		if len(args) != len(argNames) {
+			return -1, fmt.Errorf("number of arguments %d != number of argument names %d", len(args), len(argNames))
+		}
+		vars := make([]int, len(args))
+		for i, a := range args {
+			vars[i] = int(a)
+		}
+		n, err := f.eval(vars)
+		if err != nil {
+			return -1, fmt.Errorf("formula %q: %w", exprStr, err)
+		}
+		return n, nil
+	}, nil
 }
@@ -1,8 +1,14 @@
 package senddat
 
 import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
@@ -148,6 +154,24 @@ func Test_makePayloadFn(t *testing.T) {
 			wantResErr: false,
 			wantErr:    false,
 		},
+		{
+			name:    "ternary",
+			args:    args{"m < 32 ? nL+nH*256 : 3*(nL+nH*256)", []string{"m", "nL", "nH"}},
+			payload: []byte{33, 2, 1},
+			wantRes: 774,
+		},
+		{
+			name:    "unknown argument",
+			args:    args{"nL+nH*256", []string{"n"}},
+			wantErr: true,
+		},
+		{
+			name:       "division by zero",
+			args:       args{"256/n", []string{"n"}},
+			payload:    []byte{0},
+			wantRes:    -1,
+			wantResErr: true,
+		},
 		{
 			name:       "command with subcommands payload",
 			args:       args{"pL+pH*256", []string{"pL", "pH"}},
@@ -207,11 +231,11 @@ func TestCommandSpec_String(t *testing.T) {
 
 // fn is decimal (i.e. 48 = '0')
 const sampleSubCommandCSV = `prefix,cn,fn,fn_name,fn_args
-GS ""(V"",,48,Paper cut,m
-GS ""(V"",,49,Paper cut and feed,m
-FS ""(E"",,63,Set bottom logo printing,m kc1 kc2 a
-GS ""(k"",48,65,PDF417: Set the number of columns in the data region,n
-GS ""(k"",48,69,PDF417: Set the error correction level,m n
+"GS ""(V""",,48,Paper cut,m
+"GS ""(V""",,49,Paper cut and feed,m
+"FS ""(E""",,63,Set bottom logo printing,m kc1 kc2 a
+"GS ""(k""",48,65,PDF417: Set the number of columns in the data region,n
+"GS ""(k""",48,69,PDF417: Set the error correction level,m n
 `
 
 func Test_readSubcommands(t *testing.T) {
@@ -222,10 +246,45 @@ func Test_readSubcommands(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
-		want    map[string]map[string]string
+		want    map[string]map[string]*Subcommand
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{
+			name: "sample",
+			args: args{strings.NewReader(sampleSubCommandCSV), ParseString},
+			want: map[string]map[string]*Subcommand{
+				"1D 28 56": {
+					"30": {Selector: []byte{48}, Name: "Paper cut", ArgNames: []string{"m"}},
+					"31": {Selector: []byte{49}, Name: "Paper cut and feed", ArgNames: []string{"m"}},
+				},
+				"1C 28 45": {
+					"3F": {Selector: []byte{63}, Name: "Set bottom logo printing", ArgNames: []string{"m", "kc1", "kc2", "a"}},
+				},
+				"1D 28 6B": {
+					"30 41": {Selector: []byte{48, 65}, Name: "PDF417: Set the number of columns in the data region", ArgNames: []string{"n"}},
+					"30 45": {Selector: []byte{48, 69}, Name: "PDF417: Set the error correction level", ArgNames: []string{"m", "n"}},
+				},
+			},
+		},
+		{
+			name: "hex function",
+			args: args{strings.NewReader("prefix,cn,fn,fn_name,fn_args\n\"GS \"\"(k\"\"\",0x31,0x51,QR Code: Print,m\n"), ParseString},
+			want: map[string]map[string]*Subcommand{
+				"1D 28 6B": {
+					"31 51": {Selector: []byte{0x31, 0x51}, Name: "QR Code: Print", ArgNames: []string{"m"}},
+				},
+			},
+		},
+		{
+			name:    "invalid fn",
+			args:    args{strings.NewReader("prefix,cn,fn,fn_name,fn_args\n\"GS \"\"(k\"\"\",49,x,QR Code: Print,m\n"), ParseString},
+			wantErr: true,
+		},
+		{
+			name:    "invalid prefix",
+			args:    args{strings.NewReader("prefix,cn,fn,fn_name,fn_args\nFOO,49,81,QR Code: Print,m\n"), ParseString},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -240,3 +299,300 @@ func Test_readSubcommands(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadDriver(t *testing.T) {
+	for _, name := range []string{"xprinter", "escpos-3.40"} {
+		t.Run(name, func(t *testing.T) {
+			specs, err := LoadDriver(name)
+			if err != nil {
+				t.Fatalf("LoadDriver() error = %v", err)
+			}
+			assert.NotEmpty(t, specs)
+		})
+	}
+	t.Run("unknown", func(t *testing.T) {
+		_, err := LoadDriver("nope")
+		assert.Error(t, err)
+	})
+}
+
+func TestDrivers(t *testing.T) {
+	assert.Equal(t, []string{"escpos-3.40", "xprinter"}, Drivers())
+}
+
+func TestLoadDriverFunc(t *testing.T) {
+	dir := t.TempDir()
+	cmdCSV := filepath.Join(dir, "hex.csv")
+	if err := os.WriteFile(cmdCSV, []byte("prefix,name,arg_names,payload_formula\n1B 40,Initialize,,\n1D 28 6B,2D code,pL pH,pL+pH*256\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	specs, err := LoadDriverFunc(cmdCSV, ParseHexBytes)
+	if err != nil {
+		t.Fatalf("LoadDriverFunc() error = %v", err)
+	}
+	if assert.Len(t, specs, 2) {
+		assert.Equal(t, []byte{0x1b, 0x40}, specs[0].Prefix)
+		// ESC/POS functions are attached to the loaded drivers.
+		assert.NotEmpty(t, specs[1].subcommands)
+	}
+	_, err = LoadDriverFunc(cmdCSV, ParseString)
+	assert.Error(t, err, "hex prefixes are not senddat expressions")
+}
+
+func TestWithSubcommands(t *testing.T) {
+	subCSV := filepath.Join(t.TempDir(), "sub.csv")
+	if err := os.WriteFile(subCSV, []byte("prefix,cn,fn,fn_name,fn_args\n1B 28 41,,48,Beep,n t\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	specs, err := LoadDriver("escpos-3.40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := WithSubcommands(specs, subCSV, ParseHexBytes)
+	if err != nil {
+		t.Fatalf("WithSubcommands() error = %v", err)
+	}
+	prn := []byte{0x1b, '(', 'A', 3, 0, 48, 1, 2}
+	entries, err := Decode(bytes.NewReader(prn), got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "Beep", entries[0].Name())
+	}
+	// the original specs are not changed.
+	entries, err = Decode(bytes.NewReader(prn), specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "Control beeper tones", entries[0].Name())
+	}
+
+	_, err = WithSubcommands(specs, subCSV, ParseString)
+	assert.Error(t, err)
+}
+
+// TestLoadDriver_prefixes checks that no command prefix of the embedded
+// drivers is the prefix of another command, as the decoder stops at the
+// shortest match.
+func TestLoadDriver_prefixes(t *testing.T) {
+	for _, name := range Drivers() {
+		t.Run(name, func(t *testing.T) {
+			specs, err := LoadDriver(name)
+			if err != nil {
+				t.Fatalf("LoadDriver() error = %v", err)
+			}
+			for i, a := range specs {
+				for _, b := range specs[i+1:] {
+					if bytes.HasPrefix(a.Prefix, b.Prefix) || bytes.HasPrefix(b.Prefix, a.Prefix) {
+						t.Errorf("%s (% X) conflicts with %s (% X)", a.Name, a.Prefix, b.Name, b.Prefix)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestLoadDriver_escposCommands checks that the escpos driver has every
+// command of the reference, the list is written by scripts/escposdoc.
+func TestLoadDriver_escposCommands(t *testing.T) {
+	specs, err := LoadDriver("escpos-3.40")
+	if err != nil {
+		t.Fatalf("LoadDriver() error = %v", err)
+	}
+	f, err := os.Open("testdata/escpos-3.40-commands.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records[1:] {
+		code, name := rec[0], rec[1]
+		// "GS ( L" to `GS 0x28 0x4C`, the mnemonics are kept, except for
+		// the ones that senddat does not know.
+		var expr []string
+		for _, tok := range strings.Fields(code) {
+			if hex, ok := map[string]string{"ENQ": "0x05", "DC4": "0x14"}[tok]; ok {
+				tok = hex
+			}
+			if len(tok) > 1 {
+				expr = append(expr, tok)
+				continue
+			}
+			expr = append(expr, fmt.Sprintf("0x%02X", tok[0]))
+		}
+		prefix, err := ParseString(strings.Join(expr, " "))
+		if err != nil {
+			t.Fatalf("%s: %v", code, err)
+		}
+		if !slices.ContainsFunc(specs, func(cs CommandSpec) bool { return bytes.Equal(cs.Prefix, prefix) }) {
+			t.Errorf("missing %s (% X): %s", code, prefix, name)
+		}
+	}
+}
+
+func TestCommandSpec_setPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		argNames []string
+		formula  string
+		args     []byte
+		stream   []byte
+		want     []byte
+		wantErr  bool
+		readErr  bool
+	}{
+		{
+			name:    "until NUL",
+			formula: "until:00",
+			stream:  []byte{8, 16, 0, 'A'},
+			want:    []byte{8, 16, 0},
+		},
+		{
+			name:    "until without terminator",
+			formula: "until:00",
+			stream:  []byte{8, 16},
+			readErr: true,
+		},
+		{
+			name:    "invalid terminator",
+			formula: "until:zz",
+			wantErr: true,
+		},
+		{
+			name:     "repeat",
+			argNames: []string{"y", "c1", "c2"},
+			formula:  "repeat(c2-c1+1, x: y*x)",
+			args:     []byte{3, 'A', 'B'},
+			stream:   []byte{1, 1, 2, 3, 2, 4, 5, 6, 7, 8, 9, 'A'},
+			want:     []byte{1, 1, 2, 3, 2, 4, 5, 6, 7, 8, 9},
+		},
+		{
+			name:     "repeat with several header bytes",
+			argNames: []string{"n"},
+			formula:  "repeat(n, xL xH: (xL+xH*256))",
+			args:     []byte{2},
+			stream:   []byte{1, 0, 'a', 2, 0, 'b', 'c'},
+			want:     []byte{1, 0, 'a', 2, 0, 'b', 'c'},
+		},
+		{
+			name:     "repeat short stream",
+			argNames: []string{"n"},
+			formula:  "repeat(n, x: x)",
+			args:     []byte{2},
+			stream:   []byte{1, 'a', 5, 'b'},
+			readErr:  true,
+		},
+		{
+			name:     "repeat without item",
+			argNames: []string{"n"},
+			formula:  "repeat(n)",
+			wantErr:  true,
+		},
+		{
+			name:     "repeat unknown name",
+			argNames: []string{"n"},
+			formula:  "repeat(n, x: y*x)",
+			wantErr:  true,
+		},
+		{
+			name:    "repeat until",
+			formula: "repeat(3, until:3B)",
+			stream:  []byte("1;22;;9"),
+			want:    []byte("1;22;;"),
+		},
+		{
+			name:    "repeat until short stream",
+			formula: "repeat(3, until:3B)",
+			stream:  []byte("1;22;"),
+			readErr: true,
+		},
+		{
+			name:    "repeat until invalid terminator",
+			formula: "repeat(3, until:3BB)",
+			wantErr: true,
+		},
+		{
+			name:     "if then",
+			argNames: []string{"m"},
+			formula:  "if(m < 65, until:00, repeat(1, n: n))",
+			args:     []byte{4},
+			stream:   []byte{'*', '1', '*', 0, 'A'},
+			want:     []byte{'*', '1', '*', 0},
+		},
+		{
+			name:     "if else",
+			argNames: []string{"m"},
+			formula:  "if(m < 65, until:00, repeat(1, n: n))",
+			args:     []byte{69},
+			stream:   []byte{3, '*', '1', '*', 0},
+			want:     []byte{3, '*', '1', '*'},
+		},
+		{
+			name:     "if formula branch",
+			argNames: []string{"n"},
+			formula:  "if(n == 7, 1, 0)",
+			args:     []byte{7},
+			stream:   []byte{1, 2},
+			want:     []byte{1},
+		},
+		{
+			name:     "if empty branch",
+			argNames: []string{"n"},
+			formula:  "if(n == 7, 1, )",
+			args:     []byte{1},
+			stream:   []byte{1, 2},
+			want:     nil,
+		},
+		{
+			name:     "if without else",
+			argNames: []string{"n"},
+			formula:  "if(n, 1)",
+			wantErr:  true,
+		},
+		{
+			name:     "if invalid branch",
+			argNames: []string{"n"},
+			formula:  "if(n, until:zz, 1)",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := CommandSpec{ArgNames: tt.argNames, ArgCount: len(tt.argNames)}
+			err := cs.setPayload(tt.formula)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setPayload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if cs.readPayload == nil {
+				t.Fatal("readPayload is not set")
+			}
+			p, _ := NewInterpreter(bytes.NewReader(tt.stream), nil)
+			got, err := cs.readPayload(p, tt.args)
+			if (err != nil) != tt.readErr {
+				t.Fatalf("readPayload() error = %v, readErr %v", err, tt.readErr)
+			}
+			if !tt.readErr {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_readCommandSpecs_lineNumbers(t *testing.T) {
+	csv := "prefix,name,arg_names,payload_formula\n" +
+		"\"ESC \"\"@\"\"\",Initialize,,\n" +
+		"\"ESC \"\"*\"\"\",Bit image,m nL nH,nL+nH*256*k\n"
+	_, err := readCommandSpecs(strings.NewReader(csv), ParseString)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 3:")
+		assert.Contains(t, err.Error(), `unknown argument "k" at position 11`)
+	}
+}
@@ -0,0 +1,136 @@
+package senddat
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+)
+
+// datLineLen is the approximate maximum length of the generated .dat source
+// line.
+const datLineLen = 76
+
+// Bytes returns the raw bytes of the entry as they appear in the stream.
+func (e Entry) Bytes() []byte {
+	if !e.IsCommand() {
+		return e.Data
+	}
+	b := make([]byte, 0, len(e.Spec.Prefix)+len(e.Args)+len(e.Payload))
+	b = append(b, e.Spec.Prefix...)
+	b = append(b, e.Args...)
+	return append(b, e.Payload...)
+}
+
+// Dat returns the entry as senddat source, that can be parsed with [Parse]
+// to get the same bytes.  Commands are followed by the comment with the
+// command name, payload and long data are split into multiple lines.
+func (e Entry) Dat() string {
+	var buf strings.Builder
+	if e.IsCommand() {
+		buf.WriteString(datPrefix(e.Spec.Prefix))
+		for _, a := range e.Args {
+			fmt.Fprintf(&buf, " %d", a)
+		}
+		if e.Spec.Name != "" {
+			fmt.Fprintf(&buf, " '// %s", e.Spec.Name)
+		}
+		for i := 0; i < len(e.Payload); i += 16 {
+			buf.WriteString("\n\t")
+			buf.WriteString(datHex(e.Payload[i:min(i+16, len(e.Payload))]))
+		}
+		return buf.String()
+	}
+	return datText(e.Data)
+}
+
+// WriteDat writes the entries as senddat source to w, one entry per line.
+func WriteDat(w io.Writer, entries iter.Seq2[Entry, error]) error {
+	for e, err := range entries {
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, e.Dat()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isQuotable returns true if the byte can be put into the senddat string
+// literal as is.
+func isQuotable(b byte) bool {
+	return b >= 0x20 && b < 0x7F && b != '"' && b != '\\'
+}
+
+// datByte returns the byte as the control code name or hex literal.
+func datByte(b byte) string {
+	name := ControlCode(b).String()
+	if code, ok := tokenMap[name]; ok && code == ControlCode(b) {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", b)
+}
+
+// datPrefix returns the command prefix, i.e. ESC "!" or GS "(L".
+func datPrefix(prefix []byte) string {
+	var parts []string
+	for i := 0; i < len(prefix); {
+		if i == 0 || !isQuotable(prefix[i]) || prefix[i] == ' ' {
+			parts = append(parts, datByte(prefix[i]))
+			i++
+			continue
+		}
+		j := i
+		for j < len(prefix) && isQuotable(prefix[j]) && prefix[j] != ' ' {
+			j++
+		}
+		parts = append(parts, `"`+string(prefix[i:j])+`"`)
+		i = j
+	}
+	return strings.Join(parts, " ")
+}
+
+// datHex returns bytes as the space separated hex literals.
+func datHex(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("0x%02X", b)
+	}
+	return strings.Join(parts, " ")
+}
+
+// datText returns the raw data as quoted text runs and hex literals for
+// non-printable bytes, splitting long data into multiple lines.
+func datText(data []byte) string {
+	var (
+		buf     strings.Builder
+		lineLen int
+	)
+	add := func(tok string) {
+		if lineLen > 0 && lineLen+len(tok)+1 > datLineLen {
+			buf.WriteByte('\n')
+			lineLen = 0
+		}
+		if lineLen > 0 {
+			buf.WriteByte(' ')
+			lineLen++
+		}
+		buf.WriteString(tok)
+		lineLen += len(tok)
+	}
+	for i := 0; i < len(data); {
+		if !isQuotable(data[i]) {
+			add(datByte(data[i]))
+			i++
+			continue
+		}
+		j := i
+		for j < len(data) && isQuotable(data[j]) && j-i < datLineLen-2 {
+			j++
+		}
+		add(`"` + string(data[i:j]) + `"`)
+		i = j
+	}
+	return buf.String()
+}
@@ -0,0 +1,144 @@
+package senddat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntry_Dat(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry Entry
+		want  string
+	}{
+		{
+			name:  "command with args",
+			entry: Entry{Spec: &CommandSpec{Prefix: []byte{0x1B, '!'}, Name: "Select print mode(s)", ArgCount: 1}, Args: []byte{8}},
+			want:  `ESC "!" 8 '// Select print mode(s)`,
+		},
+		{
+			name:  "multi-byte prefix",
+			entry: Entry{Spec: &CommandSpec{Prefix: []byte{0x1D, '(', 'L'}}},
+			want:  `GS "(L"`,
+		},
+		{
+			name:  "prefix with space",
+			entry: Entry{Spec: &CommandSpec{Prefix: []byte{0x1B, ' '}, ArgCount: 1}, Args: []byte{0}},
+			want:  `ESC SP 0`,
+		},
+		{
+			name:  "payload",
+			entry: Entry{Spec: &CommandSpec{Prefix: []byte{0x1B, '*'}, ArgCount: 3}, Args: []byte{0, 2, 0}, Payload: []byte{0xFF, 0x01}},
+			want:  "ESC \"*\" 0 2 0\n\t0xFF 0x01",
+		},
+		{
+			name:  "text",
+			entry: Entry{Data: []byte(`Say "hi"` + "\x00\x1b\xc9")},
+			want:  `"Say " 0x22 "hi" 0x22 0x00 ESC 0xC9`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.entry.Dat())
+		})
+	}
+}
+
+func TestWriteDat_roundTrip(t *testing.T) {
+	files, err := fs.Glob(testFS, "testdata/POS/*.prn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := map[string][]byte{
+		"command cut off":  {'A', 'B', 0x1B, '!'},
+		"prefix cut off":   {'A', 'B', 0x1D},
+		"payload cut off":  {0x1D, 'v', '0', 0, 1, 0, 2, 0, 0xFF},
+		"argument cut off": {0x1D, 'v', '0', 0, 1},
+	}
+	for _, name := range files {
+		streams[name] = loadTestFile(t, name)
+	}
+	for name, prn := range streams {
+		t.Run(name, func(t *testing.T) {
+			p, err := NewInterpreter(bytes.NewReader(prn), GenericCommandSpecs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Lossless = true
+			var dat bytes.Buffer
+			if err := WriteDat(&dat, p.All()); err != nil {
+				t.Fatalf("WriteDat() error = %v", err)
+			}
+			var got bytes.Buffer
+			if err := testParser.Parse(&got, &dat); err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !bytes.Equal(prn, got.Bytes()) {
+				t.Errorf("round trip mismatch: got %d bytes, want %d bytes", got.Len(), len(prn))
+			}
+		})
+	}
+}
+
+func TestInterpreter_All_cutOff(t *testing.T) {
+	for _, prn := range [][]byte{
+		{'A', 'B', 0x1B, '!'},
+		{'A', 'B', 0x1D},
+		{0x1D, 'v', '0', 0, 1, 0, 2, 0, 0xFF},
+	} {
+		t.Run(fmt.Sprintf("% X", prn), func(t *testing.T) {
+			p, err := NewInterpreter(bytes.NewReader(prn), GenericCommandSpecs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotErr error
+			for _, err := range p.All() {
+				if err != nil {
+					gotErr = err
+				}
+			}
+			assert.ErrorIs(t, gotErr, io.ErrUnexpectedEOF)
+		})
+	}
+}
+
+func TestInterpreter_All_lossless(t *testing.T) {
+	tests := []struct {
+		name string
+		prn  []byte
+		want []Entry
+	}{
+		{
+			name: "unknown command",
+			prn:  []byte{0x1B, 'o', 'A'},
+			want: []Entry{{Offset: 0, Data: []byte{0x1B, 'o', 'A'}}},
+		},
+		{
+			name: "incomplete prefix at the end",
+			prn:  []byte{'A', 0x1B},
+			want: []Entry{{Offset: 0, Data: []byte{'A'}}, {Offset: 1, Data: []byte{0x1B}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewInterpreter(bytes.NewReader(tt.prn), genericComspecs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Lossless = true
+			var got []Entry
+			for e, err := range p.All() {
+				if err != nil {
+					t.Fatalf("All() error = %v", err)
+				}
+				got = append(got, e)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"log/slog"
 )
 
@@ -28,7 +29,8 @@ func (e *DecodeError) Unwrap() error {
 
 // Decode decodes a stream of PRN commands from the provided reader using the
 // provided command specifications. It returns a slice of Command structs or an
-// error if decoding fails.
+// error if decoding fails.  Use [Interpreter.All] to process large streams
+// without keeping all entries in memory.
 func Decode(r io.Reader, spec []CommandSpec) ([]Entry, error) {
 	// Create a new parser instance
 	p, err := NewInterpreter(r, spec)
@@ -36,6 +38,23 @@ func Decode(r io.Reader, spec []CommandSpec) ([]Entry, error) {
 		return nil, fmt.Errorf("failed to create parser: %w", err)
 	}
 
+	var commands []Entry
+	for cmd, err := range p.All() {
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+
+	return commands, nil
+}
+
+// All returns an iterator over the entries of the stream.  Entries are read
+// as the iteration progresses, so the memory use does not depend on the size
+// of the stream.  Commands marked as ignored in the specification are
+// skipped.  If an error occurs, it is yielded with an empty entry, and the
+// iteration stops.
+func (p *Interpreter) All() iter.Seq2[Entry, error] {
 	var decerr = func(offset int, msg string, err ...error) error {
 		if len(err) > 0 {
 			return &DecodeError{Message: msg, Offset: offset, Err: err[0]}
@@ -43,34 +62,37 @@ func Decode(r io.Reader, spec []CommandSpec) ([]Entry, error) {
 		return &DecodeError{Message: msg, Offset: offset}
 	}
 
-	var commands []Entry
-	var ignore bool
-LOOP:
-	for {
-		cmd, err := p.Next(ignore)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break LOOP // End of stream
+	return func(yield func(Entry, error) bool) {
+		var ignore bool
+		for {
+			cmd, err := p.Next(ignore || p.Lossless)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return // End of stream
+				}
+				yield(Entry{}, decerr(p.pos, "failed to read command", err))
+				return
+			}
+			if cmd == nil {
+				continue // Skip nil commands
+			}
+			ignore = false // Reset ignore flag for the next command
+			if cmd.IsCommand() && cmd.Spec.Ignore {
+				// to ignore false positives in the payload following the ignored command,
+				// we set the ignore flag for unknown commands.
+				// TODO: test this.
+				ignore = true
+				if !p.Lossless {
+					slog.Debug("ignoring command", "offset", cmd.Offset, "name", cmd.Name())
+					continue // Skip this command
+				}
+			}
+			slog.Debug("command", "offset", cmd.Offset, "string", cmd.String())
+			if !yield(*cmd, nil) {
+				return
 			}
-			return nil, decerr(p.pos, "failed to read command", err)
-		}
-		if cmd == nil {
-			continue // Skip nil commands
-		}
-		ignore = false // Reset ignore flag for the next command
-		if cmd.IsCommand() && cmd.Spec.Ignore {
-			// to ignore false positives in the payload following the ignored command,
-			// we set the ignore flag for unknown commands.
-			// TODO: test this.
-			ignore = true
-			slog.Debug("ignoring command", "offset", cmd.Offset, "name", cmd.Name())
-			continue // Skip this command
 		}
-		commands = append(commands, *cmd)
-		slog.Debug("command", "offset", cmd.Offset, "string", cmd.String())
 	}
-
-	return commands, nil
 }
 
 // Entry represents a single command entry in the PRN stream.
@@ -88,6 +110,10 @@ type Entry struct {
 	// Payload is the optional payload data for commands that have it, like:
 	// ESC * m nL nH data
 	Payload []byte
+	// Sub is the function of the command, selected by the first bytes of the
+	// payload, i.e. cn fn of GS ( k pL pH cn fn, if the command has
+	// functions.
+	Sub *Subcommand
 }
 
 func (e Entry) IsCommand() bool {
@@ -140,6 +166,9 @@ func (e Entry) IsValid() bool {
 }
 
 func (e Entry) Name() string {
+	if e.Sub != nil {
+		return e.Sub.Name
+	}
 	if e.Spec != nil {
 		return e.Spec.Name
 	}
@@ -169,7 +198,7 @@ func (e Entry) String() string {
 		return fmt.Sprintf("[@%6d: RAW,len=%d %q]", e.Offset, len(e.Data), e.Data)
 	}
 	fmt.Fprintf(&buf, "[@%6d: %s", e.Offset, e.Name())
-	if len(e.Args) == 0 {
+	if len(e.Args) == 0 && len(e.Payload) == 0 {
 		return buf.String() + "]"
 	}
 	args, err := e.Spec.ArgValues(e.Args)
@@ -184,6 +213,16 @@ func (e Entry) String() string {
 		if e.Spec.ArgCount > 0 {
 			buf.WriteString(fmt.Sprintf(", args=%v", argv))
 		}
+		if e.Sub != nil && len(e.Sub.ArgNames) > 0 {
+			fnArgs := e.Sub.ArgValues(e.Payload)
+			var fnArgv []string
+			for _, name := range e.Sub.ArgNames {
+				if v, ok := fnArgs[name]; ok {
+					fnArgv = append(fnArgv, fmt.Sprintf("%s=%d", name, v))
+				}
+			}
+			buf.WriteString(fmt.Sprintf(", fn args=%v", fnArgv))
+		}
 		if len(e.Payload) > 0 {
 			buf.WriteString(fmt.Sprintf(", payload=%d bytes", len(e.Payload)))
 		}
@@ -193,11 +232,21 @@ func (e Entry) String() string {
 
 }
 
+// maxPrefixLen is the maximum length of the command prefix.
+const maxPrefixLen = 8
+
 type Interpreter struct {
 	r     *bufio.Reader
-	cst   *trieNode // Trie for command specs
-	pos   int       // Running byte offset in the stream
-	limit int       // Optional read limit (0 = no limit)
+	cst   *trieNode     // Trie for command specs
+	pos   int           // Running byte offset in the stream
+	limit int           // Optional read limit (0 = no limit)
+	rec   *bytes.Buffer // bytes of the current command, if not nil
+
+	// Lossless makes [Interpreter.All] return every byte of the stream:
+	// unknown commands, and the command cut off by the end of the stream, are
+	// returned as raw data instead of an error, and commands marked as ignored
+	// are not skipped.
+	Lossless bool
 }
 
 func NewInterpreter(r io.Reader, spec []CommandSpec) (*Interpreter, error) {
@@ -212,6 +261,9 @@ func (p *Interpreter) readByte() (byte, error) {
 	b, err := p.r.ReadByte()
 	if err == nil {
 		p.pos++
+		if p.rec != nil {
+			p.rec.WriteByte(b)
+		}
 	}
 	return b, err
 }
@@ -223,12 +275,37 @@ func (p *Interpreter) readBytes(n int) ([]byte, error) {
 		return nil, nil // No bytes to read
 	}
 
-	data := make([]byte, n)
-	if _, err := io.ReadFull(p.r, data); err != nil {
+	// n comes from the stream, so the buffer grows with the bytes that are
+	// actually there, and not with the length that the stream claims.
+	var buf bytes.Buffer
+	buf.Grow(min(n, maxVarPayload))
+	m, err := io.CopyN(&buf, p.r, int64(n))
+	if p.rec != nil {
+		p.rec.Write(buf.Bytes())
+	}
+	p.pos += int(m)
+	if err != nil {
+		if errors.Is(err, io.EOF) && m > 0 {
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, fmt.Errorf("failed to read %d bytes at position %d: %w", n, p.pos, err)
 	}
-	p.pos += n
-	return data, nil
+	return buf.Bytes(), nil
+}
+
+func (p *Interpreter) readUntil(term byte) ([]byte, error) {
+	var buf []byte
+	for len(buf) < maxVarPayload {
+		b, err := p.readByte()
+		if err != nil {
+			return nil, fmt.Errorf("no terminator %02X: %w", term, err)
+		}
+		buf = append(buf, b)
+		if b == term {
+			return buf, nil
+		}
+	}
+	return nil, fmt.Errorf("no terminator %02X in %d bytes", term, maxVarPayload)
 }
 
 func (p *Interpreter) UnreadByte() error {
@@ -236,6 +313,9 @@ func (p *Interpreter) UnreadByte() error {
 		return fmt.Errorf("failed to unread byte at position %d: %w", p.pos, err)
 	}
 	p.pos--
+	if p.rec != nil && p.rec.Len() > 0 {
+		p.rec.Truncate(p.rec.Len() - 1)
+	}
 	return nil
 }
 
@@ -246,7 +326,8 @@ func (p *Interpreter) ReadByte() (byte, error) {
 // Next reads the next command or raw data entry from the input stream.
 // It returns an Entry containing the command specification, arguments, and
 // payload if applicable, or raw data if no command is recognized.
-// If ignoreUnknown is true, it will skip unknown commands and continue reading.
+// If ignoreUnknown is true, the bytes of unknown commands are returned as raw
+// data, otherwise an unknown command is an error.
 func (p *Interpreter) Next(ignoreUnknown bool) (*Entry, error) {
 	startPos := p.pos
 	var accum bytes.Buffer
@@ -286,26 +367,51 @@ func (p *Interpreter) Next(ignoreUnknown bool) (*Entry, error) {
 				Data:   accum.Bytes(),
 			}, nil // Return accumulated bytes as a raw command
 		}
-		cs, _, err := findComSpec(p.cst, p)
+		// keep the copy of the prefix bytes, in case the command is unknown.
+		var prefix [maxPrefixLen]byte
+		peeked, _ = p.r.Peek(maxPrefixLen)
+		copy(prefix[:], peeked)
+		cs, read, err := findComSpec(p.cst, p)
 		if err != nil {
 			if errors.Is(err, errUnhandled) && ignoreUnknown {
 				// If we encounter an unhandled command and ignoring unknown commands,
-				// we can skip it and continue reading.
-				slog.Debug("unhandled command", "offset", startPos, "byte", peeked[0])
+				// we treat its prefix as raw data and continue reading.
+				slog.Debug("unhandled command", "offset", startPos, "byte", prefix[0])
 				// Unread the byte so we can read the next command
 				if err := p.UnreadByte(); err != nil {
 					return nil, fmt.Errorf("failed to unread byte at position %d: %w", startPos, err)
 				}
+				accum.Write(prefix[:read-1])
 				continue // Skip this command and continue reading
 			}
+			if errors.Is(err, io.EOF) {
+				// stream ends with an incomplete command prefix.
+				if ignoreUnknown {
+					accum.Write(prefix[:read])
+					continue
+				}
+				return nil, fmt.Errorf("incomplete command at position %d: %w", startPos, io.ErrUnexpectedEOF)
+			}
 			return nil, err
 		}
 		if cs == nil {
 			// pretty much impossible at this point
 			return nil, fmt.Errorf("no command spec found at position %d", startPos)
 		}
+		// the bytes of the command are kept, in case it is cut off by the
+		// end of the stream.
+		var rec bytes.Buffer
+		rec.Write(prefix[:read])
+		p.rec = &rec
 		cmd, err := p.readCommand(cs)
+		p.rec = nil
 		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				if p.Lossless {
+					return &Entry{Offset: startPos, Data: rec.Bytes()}, nil
+				}
+				err = fmt.Errorf("command is cut off: %w", io.ErrUnexpectedEOF)
+			}
 			return nil, fmt.Errorf("failed to read entry %s at position %d: %w", cs.Name, startPos, err)
 		}
 		cmd.Offset = startPos
@@ -314,7 +420,7 @@ func (p *Interpreter) Next(ignoreUnknown bool) (*Entry, error) {
 }
 
 func (p *Interpreter) readCommand(cs *CommandSpec) (*Entry, error) {
-	if cs.ArgCount == 0 {
+	if cs.ArgCount == 0 && cs.readPayload == nil {
 		return &Entry{
 			Spec: cs,
 		}, nil
@@ -332,6 +438,18 @@ func (p *Interpreter) readCommand(cs *CommandSpec) (*Entry, error) {
 		return nil, fmt.Errorf("expected %d args for command %s, got %d", cs.ArgCount, cs.Name, len(args))
 	}
 	var payload []byte
+	if cs.readPayload != nil {
+		payload, err = cs.readPayload(p, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read payload for command %s: %w", cs.Name, err)
+		}
+		return &Entry{
+			Spec:    cs,
+			Args:    args,
+			Payload: payload,
+			Sub:     cs.subcommand(payload),
+		}, nil
+	}
 	if cs.payloadFn == nil {
 		return &Entry{
 			Spec: cs,
@@ -359,6 +477,7 @@ func (p *Interpreter) readCommand(cs *CommandSpec) (*Entry, error) {
 		Spec:    cs,
 		Args:    args,
 		Payload: payload,
+		Sub:     cs.subcommand(payload),
 	}
 	return c, nil
 }
@@ -384,8 +503,8 @@ func findComSpec(n *trieNode, r io.ByteScanner) (*CommandSpec, int, error) {
 			// I checked the ESC/POS spec and it doesn't seem that commands
 			// that have n bytes are ever used in n-1 byte form, so we can
 			// safely return the spec if it exists, as it guarantees that
-			// depth+1 does not exist.
-			// TODO: validate once the full spec is compiled.
+			// depth+1 does not exist.  TestLoadDriver_prefixes checks this for
+			// the embedded drivers.
 			if current.spec != nil {
 				return current.spec, read, nil // Found a command spec
 			}
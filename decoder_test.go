@@ -4,14 +4,17 @@ import (
 	"bytes"
 	_ "embed"
 	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 var (
-	genericComspecs, loadErr = loadCommandSpecs("drivers/escpos-3.40.csv")
+	genericComspecs, loadErr = loadCommandSpecs("drivers/escpos-3.40.csv", ParseString)
 )
 
 func init() {
@@ -398,3 +401,218 @@ func TestEntry_IsValid(t *testing.T) {
 		})
 	}
 }
+
+func TestInterpreter_All(t *testing.T) {
+	prn := toPRN(t, exampleFS, "examples/POS/weight.dat")
+	want, err := Decode(bytes.NewReader(prn), genericComspecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("all entries", func(t *testing.T) {
+		p, err := NewInterpreter(bytes.NewReader(prn), genericComspecs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []Entry
+		for e, err := range p.All() {
+			if err != nil {
+				t.Fatalf("All() error = %v", err)
+			}
+			got = append(got, e)
+		}
+		assert.Equal(t, want, got)
+	})
+	t.Run("break", func(t *testing.T) {
+		p, err := NewInterpreter(bytes.NewReader(prn), genericComspecs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []Entry
+		for e := range p.All() {
+			got = append(got, e)
+			if len(got) == 3 {
+				break
+			}
+		}
+		assert.Equal(t, want[:3], got)
+	})
+	t.Run("error", func(t *testing.T) {
+		p, err := NewInterpreter(bytes.NewReader([]byte{'A', 0x1B, 'o'}), genericComspecs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var errs []error
+		var got []Entry
+		for e, err := range p.All() {
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			got = append(got, e)
+		}
+		assert.Equal(t, []Entry{{Offset: 0, Data: []byte("A")}}, got)
+		if assert.Len(t, errs, 1) {
+			var de *DecodeError
+			assert.ErrorAs(t, errs[0], &de)
+		}
+	})
+}
+
+func TestDecode_variablePayload(t *testing.T) {
+	prn, err := testParser.ParseString(`ESC "D" 8 16 0 "a" HT ESC "&" 3 65 66 1 1 2 3 2 4 5 6 7 8 9 "AB" LF`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(bytes.NewReader(prn), GenericCommandSpecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range got {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{
+		"Set horizontal tab positions",
+		"Raw Bytes (len=1)",
+		"JMP to the next TAB position",
+		"Define user-defined characters",
+		"Raw Bytes (len=2)",
+		"Print and line feed",
+	}, names)
+	assert.Equal(t, []byte{8, 16, 0}, got[0].Payload)
+	assert.Equal(t, []byte{3, 65, 66}, got[3].Args)
+	assert.Equal(t, []byte{1, 1, 2, 3, 2, 4, 5, 6, 7, 8, 9}, got[3].Payload)
+}
+
+func TestDecode_subcommands(t *testing.T) {
+	prn, err := testParser.ParseString(`GS "(k" 4 0 49 65 50 0 GS "(k" 3 0 49 67 6 GS "(k" 3 0 49 99 0 GS "(L" 6 0 48 69 "G1" 1 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(bytes.NewReader(prn), GenericCommandSpecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, got, 4) {
+		return
+	}
+	assert.Equal(t, "QR Code: Select the model", got[0].Name())
+	assert.Equal(t, map[string]uint8{"n1": 50, "n2": 0}, got[0].Sub.ArgValues(got[0].Payload))
+	assert.Equal(t, "QR Code: Set the size of module", got[1].Name())
+	assert.Equal(t, map[string]uint8{"n": 6}, got[1].Sub.ArgValues(got[1].Payload))
+	// unknown function falls back to the command name.
+	assert.Nil(t, got[2].Sub)
+	assert.Equal(t, "Set up and print the symbol", got[2].Name())
+	assert.Equal(t, "Print the specified NV graphics data", got[3].Name())
+	assert.Equal(t, map[string]uint8{"kc1": 'G', "kc2": '1', "x": 1, "y": 1}, got[3].Sub.ArgValues(got[3].Payload))
+
+	je := got[1].JSON(EncodeHex)
+	assert.Equal(t, "QR Code: Set the size of module", je.Function)
+	assert.Equal(t, map[string]uint8{"n": 6}, je.FunctionArgs)
+}
+
+// TestDecode_escpos decodes the Epson samples with the ESC/POS command
+// database, every control byte must belong to a known command.
+func TestDecode_escpos(t *testing.T) {
+	specs, err := LoadDriver("escpos-3.40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob("testdata/POS/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		if ext != ".prn" && ext != ".dat" {
+			continue
+		}
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ext == ".dat" {
+				var buf bytes.Buffer
+				if err := testParser.Parse(&buf, bytes.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+				data = buf.Bytes()
+			}
+			entries, err := Decode(bytes.NewReader(data), specs)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			var commands int
+			for _, e := range entries {
+				if e.IsCommand() {
+					commands++
+					continue
+				}
+				// NUL is ignored by the printer, the image samples are padded
+				// with it.
+				if i := bytes.IndexFunc(e.Data, func(r rune) bool { return r > 0 && r < ' ' }); i >= 0 {
+					t.Errorf("unknown command %02X at offset %d", e.Data[i], e.Offset+i)
+				}
+			}
+			assert.NotZero(t, commands)
+		})
+	}
+}
+
+func TestDecode_largePayloadLength(t *testing.T) {
+	specs, err := LoadDriver("escpos-3.40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GS 8 L claims 2 GiB of payload, but the stream ends.
+	prn := []byte{0x1D, '8', 'L', 0xFF, 0xFF, 0xFF, 0x7F, '0'}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = Decode(bytes.NewReader(prn), specs)
+	runtime.ReadMemStats(&after)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(16<<20), "allocated for the claimed length")
+}
+
+func TestDecode_escposVarPayload(t *testing.T) {
+	specs, err := LoadDriver("escpos-3.40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2x1 Windows BMP, 8 bytes in total, the size includes "BM" and itself.
+	bmp := []byte{'B', 'M', 8, 0, 0, 0, 0xAA, 0x55}
+	tests := []struct {
+		name        string
+		prn         []byte
+		wantName    string
+		wantPayload []byte
+	}{
+		{
+			name:        "GS C ;",
+			prn:         []byte("\x1dC;1;9999;1;0;0;"),
+			wantName:    "Select count mode (B)",
+			wantPayload: []byte("1;9999;1;0;0;"),
+		},
+		{
+			name:        "GS D",
+			prn:         append([]byte{0x1D, 'D', '0', 'C', '0', ' ', ' ', 1, '1'}, bmp...),
+			wantName:    "Define Windows BMP NV graphics data",
+			wantPayload: bmp,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := Decode(bytes.NewReader(append(tt.prn, '\n')), specs)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !assert.Len(t, entries, 2) {
+				return
+			}
+			assert.Equal(t, tt.wantName, entries[0].Spec.Name)
+			assert.Equal(t, tt.wantPayload, entries[0].Payload)
+			assert.Equal(t, []byte{0x0A}, entries[1].Spec.Prefix)
+		})
+	}
+}
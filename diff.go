@@ -0,0 +1,52 @@
+package senddat
+
+import (
+	"image"
+	"image/color"
+)
+
+var (
+	diffMissing = color.RGBA{0, 0, 255, 255} // dot is in want, but not in got
+	diffExtra   = color.RGBA{255, 0, 0, 255} // dot is in got, but not in want
+	diffSame    = color.RGBA{192, 192, 192, 255}
+	diffBlank   = color.RGBA{255, 255, 255, 255}
+)
+
+// isDot returns true if the colour would be printed as a dot.
+func isDot(c color.Color) bool {
+	return color.GrayModel.Convert(c).(color.Gray).Y < 128
+}
+
+// DiffImages compares the printed dots of two images.  It returns the number
+// of dots that differ and the diff image, where the dots present in both
+// images are light gray, dots missing in got are blue, and extra dots in got
+// are red.  If images have different size, the diff image covers both, and
+// the dots outside of either image are counted as different.
+func DiffImages(want, got image.Image) (int, *image.RGBA) {
+	wb, gb := want.Bounds(), got.Bounds()
+	w := max(wb.Dx(), gb.Dx())
+	h := max(wb.Dy(), gb.Dy())
+	diff := image.NewRGBA(image.Rect(0, 0, w, h))
+	var n int
+	for y := range h {
+		for x := range w {
+			wp, gp := image.Pt(wb.Min.X+x, wb.Min.Y+y), image.Pt(gb.Min.X+x, gb.Min.Y+y)
+			wd := wp.In(wb) && isDot(want.At(wp.X, wp.Y))
+			gd := gp.In(gb) && isDot(got.At(gp.X, gp.Y))
+			c := diffBlank
+			switch {
+			case wd && gd:
+				c = diffSame
+			case wd:
+				c = diffMissing
+			case gd:
+				c = diffExtra
+			}
+			if wd != gd || (!wp.In(wb) || !gp.In(gb)) {
+				n++
+			}
+			diff.SetRGBA(x, y, c)
+		}
+	}
+	return n, diff
+}
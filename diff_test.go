@@ -0,0 +1,56 @@
+package senddat
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffImages(t *testing.T) {
+	paper := func(w, h int, dots ...image.Point) *image.Gray {
+		img := newPaper(w, h)
+		for _, p := range dots {
+			img.SetGray(p.X, p.Y, color.Gray{})
+		}
+		return img
+	}
+	tests := []struct {
+		name  string
+		want  image.Image
+		got   image.Image
+		wantN int
+		check map[image.Point]color.RGBA
+	}{
+		{
+			name:  "identical",
+			want:  paper(4, 4, image.Pt(1, 1)),
+			got:   paper(4, 4, image.Pt(1, 1)),
+			wantN: 0,
+			check: map[image.Point]color.RGBA{{1, 1}: diffSame, {0, 0}: diffBlank},
+		},
+		{
+			name:  "missing and extra",
+			want:  paper(4, 4, image.Pt(1, 1)),
+			got:   paper(4, 4, image.Pt(2, 2)),
+			wantN: 2,
+			check: map[image.Point]color.RGBA{{1, 1}: diffMissing, {2, 2}: diffExtra},
+		},
+		{
+			name:  "different size",
+			want:  paper(4, 4),
+			got:   paper(4, 6),
+			wantN: 8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, diff := DiffImages(tt.want, tt.got)
+			assert.Equal(t, tt.wantN, n)
+			for p, c := range tt.check {
+				assert.Equal(t, c, diff.RGBAAt(p.X, p.Y), "at %v", p)
+			}
+		})
+	}
+}
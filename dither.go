@@ -0,0 +1,177 @@
+package senddat
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// Dither is the algorithm used to convert the grayscale image to monochrome.
+type Dither int
+
+const (
+	// DitherThreshold prints every pixel darker than the threshold.
+	DitherThreshold Dither = iota
+	// DitherFloydSteinberg is the Floyd–Steinberg error diffusion.
+	DitherFloydSteinberg
+	// DitherAtkinson is the Atkinson error diffusion, it diffuses only 3/4 of
+	// the error, which gives more contrast on light and dark areas.
+	DitherAtkinson
+	// DitherBayer is the ordered dithering with the 8x8 Bayer matrix.
+	DitherBayer
+	// DitherJarvis is the Jarvis, Judice and Ninke error diffusion.
+	DitherJarvis
+)
+
+var ditherNames = map[Dither][]string{
+	DitherThreshold:      {"threshold", "none"},
+	DitherFloydSteinberg: {"floyd-steinberg", "fs", "floyd"},
+	DitherAtkinson:       {"atkinson"},
+	DitherBayer:          {"bayer", "ordered"},
+	DitherJarvis:         {"jarvis", "jjn"},
+}
+
+func (d Dither) String() string {
+	if names, ok := ditherNames[d]; ok {
+		return names[0]
+	}
+	return fmt.Sprintf("Dither(%d)", int(d))
+}
+
+// ParseDither returns the dithering algorithm by its name, i.e.
+// "floyd-steinberg" or "atkinson".
+func ParseDither(s string) (Dither, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d, names := range ditherNames {
+		for _, name := range names {
+			if s == name {
+				return d, nil
+			}
+		}
+	}
+	return DitherThreshold, fmt.Errorf("unknown dithering algorithm: %q", s)
+}
+
+// Set implements flag.Value.
+func (d *Dither) Set(s string) error {
+	v, err := ParseDither(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// diffusion is an error diffusion kernel element.
+type diffusion struct {
+	dx, dy int
+	weight float32
+}
+
+var (
+	kernelFloydSteinberg = []diffusion{
+		{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	}
+	kernelAtkinson = []diffusion{
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8},
+		{-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8},
+		{0, 2, 1.0 / 8},
+	}
+	kernelJarvis = []diffusion{
+		{1, 0, 7.0 / 48}, {2, 0, 5.0 / 48},
+		{-2, 1, 3.0 / 48}, {-1, 1, 5.0 / 48}, {0, 1, 7.0 / 48}, {1, 1, 5.0 / 48}, {2, 1, 3.0 / 48},
+		{-2, 2, 1.0 / 48}, {-1, 2, 3.0 / 48}, {0, 2, 5.0 / 48}, {1, 2, 3.0 / 48}, {2, 2, 1.0 / 48},
+	}
+)
+
+// bayer8 is the 8x8 Bayer threshold matrix.
+var bayer8 = [8][8]uint8{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// levels returns the pixel luminance values in range [0..1] with gamma,
+// brightness, contrast and inversion applied.
+func levels(gray *image.Gray, opts ImageOptions) []float32 {
+	gamma := opts.Gamma
+	if gamma <= 0 {
+		gamma = 1
+	}
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	lv := make([]float32, w*h)
+	for y := range h {
+		for x := range w {
+			v := float64(gray.GrayAt(gray.Rect.Min.X+x, gray.Rect.Min.Y+y).Y) / 255
+			v = (v-0.5)*(1+opts.Contrast) + 0.5 + opts.Brightness
+			v = math.Pow(clamp01(v), 1/gamma)
+			if opts.Invert {
+				v = 1 - v
+			}
+			lv[y*w+x] = float32(v)
+		}
+	}
+	return lv
+}
+
+func clamp01(v float64) float64 {
+	return min(max(v, 0), 1)
+}
+
+// dither converts the luminance levels to the bitmap using the algorithm in
+// opts.
+func dither(lv []float32, w, h int, opts ImageOptions) *Bitmap {
+	b := NewBitmap(w, h)
+	threshold := float32(cmp.Or(opts.Threshold, DefaultThreshold)) / 255
+	switch opts.Dither {
+	case DitherBayer:
+		for y := range h {
+			for x := range w {
+				t := (float32(bayer8[y%8][x%8]) + 0.5) / 64
+				b.Set(x, y, lv[y*w+x] < t)
+			}
+		}
+	case DitherFloydSteinberg:
+		diffuse(b, lv, threshold, kernelFloydSteinberg)
+	case DitherAtkinson:
+		diffuse(b, lv, threshold, kernelAtkinson)
+	case DitherJarvis:
+		diffuse(b, lv, threshold, kernelJarvis)
+	default:
+		for i, v := range lv {
+			b.Set(i%w, i/w, v < threshold)
+		}
+	}
+	return b
+}
+
+// diffuse performs the error diffusion dithering with the kernel.  It modifies
+// lv.
+func diffuse(b *Bitmap, lv []float32, threshold float32, kernel []diffusion) {
+	w, h := b.Width, b.Height
+	for y := range h {
+		for x := range w {
+			old := lv[y*w+x]
+			var val float32 = 1
+			if old < threshold {
+				val = 0
+				b.Set(x, y, true)
+			}
+			qerr := old - val
+			for _, k := range kernel {
+				nx, ny := x+k.dx, y+k.dy
+				if nx < 0 || nx >= w || ny >= h {
+					continue
+				}
+				lv[ny*w+nx] += qerr * k.weight
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package senddat
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gradient returns a horizontal gradient from black to white.
+func gradient(w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetGray(x, y, color.Gray{Y: uint8(x * 255 / (w - 1))})
+		}
+	}
+	return img
+}
+
+// density returns the ratio of printed dots in the column range [x0..x1).
+func density(b *Bitmap, x0, x1 int) float64 {
+	var n int
+	for y := range b.Height {
+		for x := x0; x < x1; x++ {
+			if b.IsSet(x, y) {
+				n++
+			}
+		}
+	}
+	return float64(n) / float64((x1-x0)*b.Height)
+}
+
+func TestParseDither(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Dither
+		wantErr bool
+	}{
+		{"threshold", "threshold", DitherThreshold, false},
+		{"none", "none", DitherThreshold, false},
+		{"floyd-steinberg", "Floyd-Steinberg", DitherFloydSteinberg, false},
+		{"fs", "fs", DitherFloydSteinberg, false},
+		{"atkinson", "atkinson", DitherAtkinson, false},
+		{"ordered", "ordered", DitherBayer, false},
+		{"jarvis", " jarvis ", DitherJarvis, false},
+		{"unknown", "sierra", DitherThreshold, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDither(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseDither() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDither_gradient(t *testing.T) {
+	const w, h = 64, 16
+	for _, d := range []Dither{DitherFloydSteinberg, DitherAtkinson, DitherBayer, DitherJarvis} {
+		t.Run(d.String(), func(t *testing.T) {
+			opts := ImageOptions{Threshold: DefaultThreshold, Dither: d}
+			b := ToBitmap(gradient(w, h), opts)
+			dark, mid, light := density(b, 0, 8), density(b, 28, 36), density(b, 56, 64)
+			assert.Greater(t, dark, 0.8, "dark end")
+			assert.InDelta(t, 0.5, mid, 0.2, "middle")
+			assert.Less(t, light, 0.2, "light end")
+		})
+	}
+}
+
+func TestDither_threshold(t *testing.T) {
+	b := ToBitmap(gradient(64, 1), ImageOptions{Threshold: DefaultThreshold})
+	assert.Equal(t, 0.5, density(b, 0, 64))
+	assert.Equal(t, 1.0, density(b, 0, 32))
+}
+
+func Test_levels(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 1, 1))
+	img.SetGray(0, 0, color.Gray{Y: 51}) // 0.2
+	tests := []struct {
+		name string
+		opts ImageOptions
+		want float32
+	}{
+		{"unchanged", ImageOptions{}, 0.2},
+		{"invert", ImageOptions{Invert: true}, 0.8},
+		{"brightness", ImageOptions{Brightness: 0.3}, 0.5},
+		{"contrast", ImageOptions{Contrast: 1}, 0},
+		{"gamma", ImageOptions{Gamma: 0.5}, 0.04},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := levels(img, tt.opts)
+			assert.InDelta(t, tt.want, got[0], 0.001)
+		})
+	}
+}
@@ -0,0 +1,469 @@
+package senddat
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Payload formula language
+//
+// The payload formula of the command CSV is an integer expression of the
+// command arguments, with the C operators and precedence:
+//
+//	cond ? a : b                 conditional
+//	||  &&                       logical, the result is 1 or 0
+//	|  ^  &                      bitwise
+//	==  !=  <  <=  >  >=         comparison, the result is 1 or 0
+//	<<  >>                       shifts
+//	+  -  *  /  %                arithmetic, division truncates
+//	-x  !x  ~x                   unary
+//	min(a, b, ...)  max(a, b, ...)  abs(x)
+//
+// Integers are decimal, or hexadecimal with the 0x prefix.  Identifiers are
+// the argument names, they are resolved when the formula is compiled, so that
+// the errors are reported when the CSV is loaded.
+
+// formula is the compiled payload formula.
+type formula struct {
+	src  string
+	root fnode
+}
+
+// compileFormula parses the formula, names are the names of the variables, in
+// the order of values passed to eval.
+func compileFormula(src string, names []string) (*formula, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	fp := formulaParser{toks: toks, names: names}
+	root, err := fp.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := fp.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+	return &formula{src: src, root: root}, nil
+}
+
+// eval evaluates the formula with the variable values.
+func (f *formula) eval(vars []int) (int, error) {
+	return f.root.eval(vars)
+}
+
+func (f *formula) String() string {
+	return f.src
+}
+
+var errDivisionByZero = errors.New("division by zero")
+
+// fnode is the node of the formula syntax tree.
+type fnode interface {
+	eval(vars []int) (int, error)
+}
+
+type (
+	numNode   int
+	varNode   int // index of the variable
+	unaryNode struct {
+		op string
+		x  fnode
+	}
+	binaryNode struct {
+		op   string
+		x, y fnode
+	}
+	condNode struct {
+		cond, then, els fnode
+	}
+	callNode struct {
+		fn   func(args []int) int
+		args []fnode
+	}
+)
+
+func (n numNode) eval([]int) (int, error) { return int(n), nil }
+
+func (n varNode) eval(vars []int) (int, error) {
+	if int(n) >= len(vars) {
+		return 0, fmt.Errorf("variable %d is not set", n)
+	}
+	return vars[n], nil
+}
+
+func (n unaryNode) eval(vars []int) (int, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "-":
+		return -x, nil
+	case "!":
+		return btoi(x == 0), nil
+	case "~":
+		return ^x, nil
+	}
+	return 0, fmt.Errorf("unsupported unary operator: %s", n.op)
+}
+
+func (n binaryNode) eval(vars []int) (int, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	// logical operators do not evaluate the right side, if the left side
+	// decides the result.
+	switch {
+	case n.op == "&&" && x == 0:
+		return 0, nil
+	case n.op == "||" && x != 0:
+		return 1, nil
+	}
+	y, err := n.y.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			return 0, errDivisionByZero
+		}
+		if n.op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "<<", ">>":
+		if y < 0 || y > 63 {
+			return 0, fmt.Errorf("invalid shift count: %d", y)
+		}
+		if n.op == "<<" {
+			return x << y, nil
+		}
+		return x >> y, nil
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	case "==":
+		return btoi(x == y), nil
+	case "!=":
+		return btoi(x != y), nil
+	case "<":
+		return btoi(x < y), nil
+	case "<=":
+		return btoi(x <= y), nil
+	case ">":
+		return btoi(x > y), nil
+	case ">=":
+		return btoi(x >= y), nil
+	case "&&", "||":
+		return btoi(y != 0), nil
+	}
+	return 0, fmt.Errorf("unsupported operator: %s", n.op)
+}
+
+func (n condNode) eval(vars []int) (int, error) {
+	c, err := n.cond.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	if c != 0 {
+		return n.then.eval(vars)
+	}
+	return n.els.eval(vars)
+}
+
+func (n callNode) eval(vars []int) (int, error) {
+	args := make([]int, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	return n.fn(args), nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// formulaFunc is the function of the formula language.
+type formulaFunc struct {
+	minArgs, maxArgs int // maxArgs < 0 is unlimited
+	fn               func(args []int) int
+}
+
+var formulaFuncs = map[string]formulaFunc{
+	"min": {1, -1, func(args []int) int {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = min(m, a)
+		}
+		return m
+	}},
+	"max": {1, -1, func(args []int) int {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = max(m, a)
+		}
+		return m
+	}},
+	"abs": {1, 1, func(args []int) int {
+		if args[0] < 0 {
+			return -args[0]
+		}
+		return args[0]
+	}},
+}
+
+// binaryPrec is the precedence of the binary operators, higher binds
+// tighter.
+var binaryPrec = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNum
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int // 1-based position in the formula
+}
+
+// operators are the operator tokens, the longer ones first.
+var operators = []string{
+	"<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "&", "|", "^", "!", "~", "?", ":", "(", ")", ",",
+}
+
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(src) && (isIdentChar(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, token{tokNum, src[i:j], i + 1})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && isIdentChar(rune(src[j])) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j], i + 1})
+			i = j
+		default:
+			var op string
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i+1)
+			}
+			toks = append(toks, token{tokOp, op, i + 1})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, text: "end of formula", pos: len(src) + 1}), nil
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+type formulaParser struct {
+	toks  []token
+	i     int
+	names []string
+}
+
+func (fp *formulaParser) peek() token {
+	return fp.toks[fp.i]
+}
+
+func (fp *formulaParser) next() token {
+	t := fp.toks[fp.i]
+	if t.kind != tokEOF {
+		fp.i++
+	}
+	return t
+}
+
+// isOp returns true if the next token is the operator.
+func (fp *formulaParser) isOp(op string) bool {
+	t := fp.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (fp *formulaParser) expect(op string) error {
+	if t := fp.next(); t.kind != tokOp || t.text != op {
+		return fmt.Errorf("expected %q, got %q at position %d", op, t.text, t.pos)
+	}
+	return nil
+}
+
+// parseExpr parses the conditional expression.
+func (fp *formulaParser) parseExpr() (fnode, error) {
+	cond, err := fp.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if !fp.isOp("?") {
+		return cond, nil
+	}
+	fp.next()
+	then, err := fp.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := fp.expect(":"); err != nil {
+		return nil, err
+	}
+	els, err := fp.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return condNode{cond: cond, then: then, els: els}, nil
+}
+
+// parseBinary parses the binary operators with the precedence of at least
+// minPrec.
+func (fp *formulaParser) parseBinary(minPrec int) (fnode, error) {
+	x, err := fp.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := fp.peek()
+		prec, ok := binaryPrec[t.text]
+		if t.kind != tokOp || !ok || prec < minPrec {
+			return x, nil
+		}
+		fp.next()
+		y, err := fp.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryNode{op: t.text, x: x, y: y}
+	}
+}
+
+func (fp *formulaParser) parseUnary() (fnode, error) {
+	t := fp.peek()
+	if t.kind == tokOp && (t.text == "-" || t.text == "!" || t.text == "~") {
+		fp.next()
+		x, err := fp.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: t.text, x: x}, nil
+	}
+	return fp.parsePrimary()
+}
+
+func (fp *formulaParser) parsePrimary() (fnode, error) {
+	t := fp.next()
+	switch t.kind {
+	case tokNum:
+		base := 0
+		if len(t.text) > 1 && t.text[0] == '0' && unicode.IsDigit(rune(t.text[1])) {
+			base = 10 // not octal.
+		}
+		n, err := strconv.ParseInt(t.text, base, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return numNode(n), nil
+	case tokIdent:
+		if fp.isOp("(") {
+			return fp.parseCall(t)
+		}
+		for i, name := range fp.names {
+			if name == t.text {
+				return varNode(i), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown argument %q at position %d, have: %s", t.text, t.pos, strings.Join(fp.names, " "))
+	case tokOp:
+		if t.text == "(" {
+			x, err := fp.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := fp.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+// parseCall parses the function call, the name is already read.
+func (fp *formulaParser) parseCall(name token) (fnode, error) {
+	fn, ok := formulaFuncs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	fp.next() // (
+	var args []fnode
+	for !fp.isOp(")") {
+		if len(args) > 0 {
+			if err := fp.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := fp.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	fp.next() // )
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments for %s: %d at position %d", name.text, len(args), name.pos)
+	}
+	return callNode{fn: fn.fn, args: args}, nil
+}
@@ -0,0 +1,72 @@
+package senddat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_compileFormula(t *testing.T) {
+	names := []string{"m", "nL", "nH", "n"}
+	vars := []int{33, 2, 1, 7}
+	tests := []struct {
+		name    string
+		src     string
+		want    int
+		wantErr bool
+		evalErr bool
+	}{
+		{"number", "42", 42, false, false},
+		{"hex", "0x1F", 31, false, false},
+		{"leading zero is decimal", "010", 10, false, false},
+		{"precedence", "nL+nH*256", 258, false, false},
+		{"parentheses", "(nL+nH)*256", 768, false, false},
+		{"left associative", "10-4-3", 3, false, false},
+		{"modulo", "n%4", 3, false, false},
+		{"shifts", "nL<<8 | nH>>1", 512, false, false},
+		{"mask", "m & 0x0F", 1, false, false},
+		{"xor", "n ^ 1", 6, false, false},
+		{"comparison", "m >= 32", 1, false, false},
+		{"equality", "n == 7 && m != 0", 1, false, false},
+		{"logical or", "n < 0 || m > 32", 1, false, false},
+		{"not", "!n", 0, false, false},
+		{"unary minus", "-n + 10", 3, false, false},
+		{"complement", "~0", -1, false, false},
+		{"ternary", "m < 32 ? nL+nH*256 : 3*(nL+nH*256)", 774, false, false},
+		{"nested ternary", "n == 1 ? 10 : n == 7 ? 20 : 30", 20, false, false},
+		{"comparison binds tighter than ternary", "m > 32 ? 1 : 0", 1, false, false},
+		{"min", "min(n, 5, 6)", 5, false, false},
+		{"max", "max(nL, nH)", 2, false, false},
+		{"abs", "abs(nH - nL)", 1, false, false},
+		{"short circuit", "0 && 1/0", 0, false, false},
+		{"division by zero", "1/(n-7)", 0, false, true},
+		{"invalid shift", "1 << 64", 0, false, true},
+		{"unknown argument", "nL + x", 0, true, false},
+		{"unknown function", "sqrt(n)", 0, true, false},
+		{"abs arity", "abs(n, m)", 0, true, false},
+		{"missing colon", "m ? 1", 0, true, false},
+		{"unbalanced", "(n + 1", 0, true, false},
+		{"trailing", "n 1", 0, true, false},
+		{"invalid character", "n $ 1", 0, true, false},
+		{"invalid number", "0xZZ", 0, true, false},
+		{"empty", "", 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := compileFormula(tt.src, names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileFormula() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := f.eval(vars)
+			if (err != nil) != tt.evalErr {
+				t.Fatalf("eval() error = %v, evalErr %v", err, tt.evalErr)
+			}
+			if !tt.evalErr {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package senddat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hexDumpWidth is the number of bytes in a hex dump row.
+const hexDumpWidth = 16
+
+// HexDump returns the annotated hex dump of the entry: the offset, hex and
+// ASCII columns, in rows of 16 bytes, starting at the entry offset.  The
+// first row is annotated with the command name and arguments, or the data
+// length.
+func (e Entry) HexDump() string {
+	data := e.Bytes()
+	var buf strings.Builder
+	for i := 0; i < len(data) || i == 0; i += hexDumpWidth {
+		row := data[i:min(i+hexDumpWidth, len(data))]
+		fmt.Fprintf(&buf, "%08x  ", e.Offset+i)
+		for j := range hexDumpWidth {
+			if j < len(row) {
+				fmt.Fprintf(&buf, "%02x ", row[j])
+			} else {
+				buf.WriteString("   ")
+			}
+			if j == hexDumpWidth/2-1 {
+				buf.WriteByte(' ')
+			}
+		}
+		var ascii strings.Builder
+		for _, b := range row {
+			if b >= 0x20 && b < 0x7F {
+				ascii.WriteByte(b)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(&buf, " %-18s", "|"+ascii.String()+"|")
+		if i == 0 {
+			buf.WriteString(" " + e.annotation())
+		}
+		buf.WriteByte('\n')
+		if len(data) == 0 {
+			break
+		}
+	}
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// annotation returns the command name with arguments in the order of the
+// specification, or the data description.
+func (e Entry) annotation() string {
+	if !e.IsCommand() {
+		return fmt.Sprintf("data, %d bytes", len(e.Data))
+	}
+	var buf strings.Builder
+	buf.WriteString(e.Name())
+	for i, a := range e.Args {
+		name := fmt.Sprintf("arg%d", i)
+		if i < len(e.Spec.ArgNames) {
+			name = e.Spec.ArgNames[i]
+		}
+		fmt.Fprintf(&buf, " %s=%d", name, a)
+	}
+	if e.Sub != nil {
+		params := e.Payload[min(len(e.Sub.Selector), len(e.Payload)):]
+		for i, name := range e.Sub.ArgNames {
+			if i >= len(params) {
+				break
+			}
+			fmt.Fprintf(&buf, " %s=%d", name, params[i])
+		}
+	}
+	if len(e.Payload) > 0 {
+		fmt.Fprintf(&buf, ", payload %d bytes", len(e.Payload))
+	}
+	return buf.String()
+}
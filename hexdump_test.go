@@ -0,0 +1,70 @@
+package senddat
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntry_HexDump(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry Entry
+		want  string
+	}{
+		{
+			name:  "command",
+			entry: Entry{Offset: 2, Spec: &CommandSpec{Prefix: []byte{0x1B, '3'}, Name: "Set line spacing", ArgCount: 1, ArgNames: []string{"n"}}, Args: []byte{18}},
+			want:  "00000002  1b 33 12                                          |.3.|              Set line spacing n=18",
+		},
+		{
+			name:  "data",
+			entry: Entry{Offset: 16, Data: []byte("0123456789ABCDEF\x00")},
+			want: "00000010  30 31 32 33 34 35 36 37  38 39 41 42 43 44 45 46  |0123456789ABCDEF| data, 17 bytes\n" +
+				"00000020  00                                                |.|               ",
+		},
+		{
+			name:  "payload",
+			entry: Entry{Spec: &CommandSpec{Prefix: []byte{0x1B, '*'}, Name: "Bit image", ArgCount: 3, ArgNames: []string{"m", "nL", "nH"}}, Args: []byte{0, 1, 0}, Payload: []byte{0xFF}},
+			want:  "00000000  1b 2a 00 01 00 ff                                 |.*....|           Bit image m=0 nL=1 nH=0, payload 1 bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.entry.HexDump())
+		})
+	}
+}
+
+func TestEntry_HexDump_stream(t *testing.T) {
+	// hex columns of the dump must reproduce the stream.
+	prn := loadTestFile(t, "testdata/POS/receipt.prn")
+	p, err := NewInterpreter(bytes.NewReader(prn), GenericCommandSpecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Lossless = true
+	var got []byte
+	for e, err := range p.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range strings.Split(e.HexDump(), "\n") {
+			off, err := strconv.ParseInt(row[:8], 16, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(got), int(off))
+			b, err := hex.DecodeString(strings.ReplaceAll(row[10:59], " ", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, b...)
+		}
+	}
+	assert.Equal(t, prn, got)
+}
@@ -0,0 +1,340 @@
+package senddat
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	xdraw "golang.org/x/image/draw"
+)
+
+// ImageMode is the ESC/POS command used to output the image.
+type ImageMode int
+
+const (
+	// ImageRaster outputs the image with GS v 0 raster bit image command.
+	ImageRaster ImageMode = iota
+	// ImageColumn outputs the image with ESC * 24-dot double density bit
+	// image command, in stripes of 24 dots high.  Use it for printers that do
+	// not support GS v 0.
+	ImageColumn
+)
+
+// Alignment is the horizontal alignment of the image, as understood by ESC a.
+type Alignment int
+
+const (
+	AlignLeft Alignment = iota
+	AlignCenter
+	AlignRight
+)
+
+// DefaultThreshold is the default luminance threshold, pixels that are darker
+// are printed.
+const DefaultThreshold = 128
+
+// ImageOptions are the image conversion options.
+type ImageOptions struct {
+	// Width is the target width in dots.  If zero, the image is not scaled.
+	// Height is scaled proportionally.
+	Width int
+	// MaxWidth is the printable width in dots, wider images are scaled down
+	// to it.  Zero means no limit.
+	MaxWidth int
+	// Threshold is the luminance threshold [1..255], pixels that are darker
+	// are printed.  Zero means DefaultThreshold.
+	Threshold uint8
+	// Align is the horizontal alignment of the image.
+	Align Alignment
+	// Mode is the command used to output the image.
+	Mode ImageMode
+	// Dither is the algorithm used to convert the image to monochrome.
+	Dither Dither
+	// Gamma is the gamma correction, values above 1 lighten the image.  Zero
+	// means no correction.
+	Gamma float64
+	// Brightness is added to the luminance, range [-1..1], 0 is unchanged.
+	Brightness float64
+	// Contrast is the contrast adjustment, range [-1..1], 0 is unchanged.
+	Contrast float64
+	// Invert inverts the image.
+	Invert bool
+}
+
+// DefaultImageOptions are the image options used for the "#" directive, if
+// the directive line does not override them.
+var DefaultImageOptions = ImageOptions{
+	Threshold: DefaultThreshold,
+	Align:     AlignLeft,
+	Mode:      ImageRaster,
+}
+
+var errInvalidImageParam = errors.New("invalid image parameter")
+
+// Bitmap is a monochrome image.  Pixels are packed 8 per byte, most
+// significant bit first, each row is padded to the whole byte.  Bit set to 1
+// means the dot is printed.
+type Bitmap struct {
+	Width  int
+	Height int
+	Stride int // bytes per row
+	Pix    []byte
+}
+
+// NewBitmap returns a blank bitmap of the given size.
+func NewBitmap(width, height int) *Bitmap {
+	stride := (width + 7) / 8
+	return &Bitmap{
+		Width:  width,
+		Height: height,
+		Stride: stride,
+		Pix:    make([]byte, stride*height),
+	}
+}
+
+// Set sets the dot at x, y.
+func (b *Bitmap) Set(x, y int, on bool) {
+	if x < 0 || y < 0 || x >= b.Width || y >= b.Height {
+		return
+	}
+	mask := byte(0x80 >> (x % 8))
+	if on {
+		b.Pix[y*b.Stride+x/8] |= mask
+	} else {
+		b.Pix[y*b.Stride+x/8] &^= mask
+	}
+}
+
+// IsSet returns true if the dot at x, y is printed.
+func (b *Bitmap) IsSet(x, y int) bool {
+	if x < 0 || y < 0 || x >= b.Width || y >= b.Height {
+		return false
+	}
+	return b.Pix[y*b.Stride+x/8]&(0x80>>(x%8)) != 0
+}
+
+// LoadImage loads the PNG, GIF, JPEG or BMP image from the file.
+func LoadImage(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening image '%s': %w", filename, err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image '%s': %w", filename, err)
+	}
+	return img, nil
+}
+
+// grayscale scales the image to the width (keeping the aspect ratio) and
+// converts it to grayscale.  Transparent areas become white.
+func grayscale(img image.Image, width int) *image.Gray {
+	sb := img.Bounds()
+	if width <= 0 {
+		width = sb.Dx()
+	}
+	height := sb.Dy() * width / max(sb.Dx(), 1)
+	if height == 0 && sb.Dy() > 0 {
+		height = 1
+	}
+	dst := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	if width == sb.Dx() && height == sb.Dy() {
+		draw.Draw(dst, dst.Bounds(), img, sb.Min, draw.Over)
+	} else {
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, sb, xdraw.Over, nil)
+	}
+	return dst
+}
+
+// ToBitmap converts the image to monochrome bitmap using the options.
+func ToBitmap(img image.Image, opts ImageOptions) *Bitmap {
+	width := opts.Width
+	if opts.MaxWidth > 0 {
+		if width <= 0 {
+			width = img.Bounds().Dx()
+		}
+		width = min(width, opts.MaxWidth)
+	}
+	gray := grayscale(img, width)
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	return dither(levels(gray, opts), w, h, opts)
+}
+
+// WriteImage writes the bitmap to w as ESC/POS commands, according to the
+// mode and alignment in the options.
+func WriteImage(w io.Writer, b *Bitmap, opts ImageOptions) error {
+	var data []byte
+	switch opts.Mode {
+	case ImageRaster:
+		data = encodeRaster(b)
+	case ImageColumn:
+		data = encodeColumns(b)
+	default:
+		return fmt.Errorf("unsupported image mode: %d", opts.Mode)
+	}
+	if opts.Align != AlignLeft {
+		data = append([]byte{byte(bESC), 'a', byte(opts.Align)}, data...)
+		data = append(data, byte(bESC), 'a', byte(AlignLeft))
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// encodeRaster encodes the bitmap as GS v 0 m xL xH yL yH d1...dk.
+func encodeRaster(b *Bitmap) []byte {
+	buf := make([]byte, 0, 8+len(b.Pix))
+	buf = append(buf,
+		byte(bGS), 'v', '0', 0,
+		byte(b.Stride), byte(b.Stride>>8),
+		byte(b.Height), byte(b.Height>>8),
+	)
+	return append(buf, b.Pix...)
+}
+
+// stripeHeight is the height of the stripe in ESC * 24-dot modes.
+const stripeHeight = 24
+
+// encodeColumns encodes the bitmap as a sequence of ESC * 33 nL nH d1...dk
+// 24-dot stripes.  Line spacing is set to 24 dots, so that stripes connect,
+// and restored to default afterwards.
+func encodeColumns(b *Bitmap) []byte {
+	var buf []byte
+	buf = append(buf, byte(bESC), '3', stripeHeight)
+	for top := 0; top < b.Height; top += stripeHeight {
+		buf = append(buf, byte(bESC), '*', 33, byte(b.Width), byte(b.Width>>8))
+		for x := range b.Width {
+			for k := range stripeHeight / 8 {
+				var col byte
+				for bit := range 8 {
+					if b.IsSet(x, top+k*8+bit) {
+						col |= 0x80 >> bit
+					}
+				}
+				buf = append(buf, col)
+			}
+		}
+		buf = append(buf, byte(bLF))
+	}
+	buf = append(buf, byte(bESC), '2')
+	return buf
+}
+
+// parseImageDirective parses the "#" directive line, which is the filename,
+// optionally in double quotes, followed by the space separated key=value
+// parameters, i.e.:
+//
+//	#logo.png width=384 threshold=100 align=center mode=column dither=atkinson
+func parseImageDirective(line string, defaults ImageOptions) (string, ImageOptions, error) {
+	opts := defaults
+	line = strings.TrimSpace(line)
+	var filename string
+	if strings.HasPrefix(line, `"`) {
+		q, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return "", opts, fmt.Errorf("invalid quoted filename: %w", err)
+		}
+		filename, _ = strconv.Unquote(q)
+		line = line[len(q):]
+	} else {
+		filename, line, _ = strings.Cut(line, " ")
+	}
+	if filename == "" {
+		return "", opts, errors.New("missing image filename")
+	}
+	for _, param := range strings.Fields(line) {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return "", opts, fmt.Errorf("%w: %q, expected key=value", errInvalidImageParam, param)
+		}
+		if err := opts.set(strings.ToLower(key), strings.ToLower(value)); err != nil {
+			return "", opts, err
+		}
+	}
+	return filename, opts, nil
+}
+
+// set sets the option by its directive parameter name.
+func (opts *ImageOptions) set(key, value string) error {
+	switch key {
+	case "width":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: width=%s", errInvalidImageParam, value)
+		}
+		opts.Width = n
+	case "threshold":
+		n, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return fmt.Errorf("%w: threshold=%s", errInvalidImageParam, value)
+		}
+		opts.Threshold = uint8(n)
+	case "align":
+		switch value {
+		case "left", "0":
+			opts.Align = AlignLeft
+		case "center", "centre", "1":
+			opts.Align = AlignCenter
+		case "right", "2":
+			opts.Align = AlignRight
+		default:
+			return fmt.Errorf("%w: align=%s", errInvalidImageParam, value)
+		}
+	case "mode":
+		switch value {
+		case "raster":
+			opts.Mode = ImageRaster
+		case "column":
+			opts.Mode = ImageColumn
+		default:
+			return fmt.Errorf("%w: mode=%s", errInvalidImageParam, value)
+		}
+	case "dither":
+		d, err := ParseDither(value)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errInvalidImageParam, err)
+		}
+		opts.Dither = d
+	case "gamma", "brightness", "contrast":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s=%s", errInvalidImageParam, key, value)
+		}
+		switch key {
+		case "gamma":
+			opts.Gamma = f
+		case "brightness":
+			opts.Brightness = f
+		case "contrast":
+			opts.Contrast = f
+		}
+	case "invert":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%w: invert=%s", errInvalidImageParam, value)
+		}
+		opts.Invert = b
+	default:
+		return fmt.Errorf("%w: unknown parameter %q", errInvalidImageParam, key)
+	}
+	return nil
+}
+
+// includeImage decodes the image from r, converts it and writes it to w.
+func includeImage(w io.Writer, r io.Reader, opts ImageOptions) error {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return fmt.Errorf("error decoding image: %w", err)
+	}
+	return WriteImage(w, ToBitmap(img, opts), opts)
+}
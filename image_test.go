@@ -0,0 +1,215 @@
+package senddat
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testImage returns a 10x2 image with the left half black, and the right half
+// white, and the second row transparent.
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 2))
+	for x := range 10 {
+		c := color.NRGBA{0, 0, 0, 255}
+		if x >= 5 {
+			c = color.NRGBA{255, 255, 255, 255}
+		}
+		img.SetNRGBA(x, 0, c)
+		img.SetNRGBA(x, 1, color.NRGBA{0, 0, 0, 0})
+	}
+	return img
+}
+
+func writeTestPNG(t *testing.T, dir string, name string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestToBitmap(t *testing.T) {
+	b := ToBitmap(testImage(), DefaultImageOptions)
+	assert.Equal(t, 10, b.Width)
+	assert.Equal(t, 2, b.Height)
+	assert.Equal(t, 2, b.Stride)
+	assert.Equal(t, []byte{0xF8, 0x00, 0x00, 0x00}, b.Pix)
+}
+
+func TestToBitmap_scale(t *testing.T) {
+	b := ToBitmap(testImage(), ImageOptions{Width: 20, Threshold: DefaultThreshold})
+	assert.Equal(t, 20, b.Width)
+	assert.Equal(t, 4, b.Height)
+	assert.True(t, b.IsSet(0, 0))
+	assert.False(t, b.IsSet(19, 0))
+	assert.False(t, b.IsSet(0, 3))
+}
+
+func TestToBitmap_partialOptions(t *testing.T) {
+	black := image.NewGray(image.Rect(0, 0, 8, 8))
+	tests := []struct {
+		name string
+		opts ImageOptions
+	}{
+		{"width", ImageOptions{Width: 16}},
+		{"max width", ImageOptions{MaxWidth: 4}},
+		{"dither", ImageOptions{Dither: DitherAtkinson}},
+		{"default", DefaultImageOptions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := ToBitmap(black, tt.opts)
+			for y := range b.Height {
+				for x := range b.Width {
+					if !b.IsSet(x, y) {
+						t.Fatalf("dot %d,%d is not set", x, y)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewParser_imageOptions(t *testing.T) {
+	p := NewParser(Options{Image: ImageOptions{Width: 16, Dither: DitherBayer}})
+	want := DefaultImageOptions
+	want.Width, want.Dither = 16, DitherBayer
+	assert.Equal(t, want, p.opts.Image)
+}
+
+func Test_encodeRaster(t *testing.T) {
+	b := ToBitmap(testImage(), DefaultImageOptions)
+	want := []byte{0x1D, 'v', '0', 0, 2, 0, 2, 0, 0xF8, 0x00, 0x00, 0x00}
+	assert.Equal(t, want, encodeRaster(b))
+}
+
+func Test_encodeColumns(t *testing.T) {
+	b := NewBitmap(2, 9)
+	b.Set(0, 0, true)
+	b.Set(1, 8, true)
+	got := encodeColumns(b)
+	want := []byte{
+		0x1B, '3', 24,
+		0x1B, '*', 33, 2, 0,
+		0x80, 0x00, 0x00,
+		0x00, 0x80, 0x00,
+		0x0A,
+		0x1B, '2',
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestWriteImage_align(t *testing.T) {
+	var buf bytes.Buffer
+	b := NewBitmap(8, 1)
+	if err := WriteImage(&buf, b, ImageOptions{Align: AlignCenter}); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x1B, 'a', 1, 0x1D, 'v', '0', 0, 1, 0, 1, 0, 0, 0x1B, 'a', 0}
+	assert.Equal(t, want, buf.Bytes())
+}
+
+func Test_parseImageDirective(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		wantFile string
+		wantOpts ImageOptions
+		wantErr  bool
+	}{
+		{
+			name:     "filename only",
+			line:     "logo.png",
+			wantFile: "logo.png",
+			wantOpts: DefaultImageOptions,
+		},
+		{
+			name:     "all parameters",
+			line:     "logo.png width=384 threshold=100 align=center mode=column",
+			wantFile: "logo.png",
+			wantOpts: ImageOptions{Width: 384, Threshold: 100, Align: AlignCenter, Mode: ImageColumn},
+		},
+		{
+			name:     "dithering parameters",
+			line:     "photo.jpg dither=atkinson gamma=1.8 brightness=-0.1 contrast=0.2 invert=true",
+			wantFile: "photo.jpg",
+			wantOpts: ImageOptions{Threshold: DefaultThreshold, Dither: DitherAtkinson, Gamma: 1.8, Brightness: -0.1, Contrast: 0.2, Invert: true},
+		},
+		{
+			name:    "unknown dither",
+			line:    "photo.jpg dither=sierra",
+			wantErr: true,
+		},
+		{
+			name:     "quoted filename",
+			line:     `"my logo.png" align=right`,
+			wantFile: "my logo.png",
+			wantOpts: ImageOptions{Threshold: DefaultThreshold, Align: AlignRight},
+		},
+		{
+			name:    "missing filename",
+			line:    "",
+			wantErr: true,
+		},
+		{
+			name:    "unknown parameter",
+			line:    "logo.png colour=red",
+			wantErr: true,
+		},
+		{
+			name:    "invalid threshold",
+			line:    "logo.png threshold=256",
+			wantErr: true,
+		},
+		{
+			name:    "not key=value",
+			line:    "logo.png center",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotFile, gotOpts, err := parseImageDirective(tt.line, DefaultImageOptions)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseImageDirective() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equal(t, tt.wantFile, gotFile)
+			assert.Equal(t, tt.wantOpts, gotOpts)
+		})
+	}
+}
+
+func TestParse_image(t *testing.T) {
+	filename := writeTestPNG(t, t.TempDir(), "logo.png")
+	src := `ESC "@"` + "\n#" + filename + " align=center\n" + `"OK" LF`
+	var buf bytes.Buffer
+	if err := testParser.Parse(&buf, strings.NewReader(src)); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []byte{
+		0x1B, '@',
+		0x1B, 'a', 1,
+		0x1D, 'v', '0', 0, 2, 0, 2, 0, 0xF8, 0x00, 0x00, 0x00,
+		0x1B, 'a', 0,
+		'O', 'K', 0x0A,
+	}
+	assert.Equal(t, want, buf.Bytes())
+}
@@ -0,0 +1,157 @@
+package senddat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/scanner"
+)
+
+// DefaultMaxIncludeDepth is the default maximum nesting of the source
+// includes.
+const DefaultMaxIncludeDepth = 16
+
+var (
+	errIncludeCycle = errors.New("include cycle")
+	errIncludeDepth = errors.New("include depth exceeded")
+)
+
+// IncludeError is the error in the included source file.
+type IncludeError struct {
+	// Stack is the positions of the include commands, the outermost first.
+	Stack []string
+	Err   error
+}
+
+func (e *IncludeError) Error() string {
+	stack := slices.Clone(e.Stack)
+	slices.Reverse(stack)
+	return fmt.Sprintf("%s (included from %s)", e.Err, strings.Join(stack, " <- "))
+}
+
+func (e *IncludeError) Unwrap() error {
+	return e.Err
+}
+
+// includeState is the state of the source file being parsed.
+type includeState struct {
+	name  string   // file name, empty for the unnamed input
+	dir   string   // directory to resolve the relative includes
+	stack []string // positions of the include commands, the outermost first
+	files []string // files being parsed, for cycle detection
+	cp    *codePageState
+}
+
+// newIncludeState returns the state for the top level source.  If r has a
+// name, the includes are resolved relative to it.
+func newIncludeState(r io.Reader) *includeState {
+	nr, ok := r.(interface{ Name() string })
+	if !ok {
+		return &includeState{}
+	}
+	return newFileState(nr.Name())
+}
+
+// newFileState returns the state for the top level source file.
+func newFileState(name string) *includeState {
+	return &includeState{
+		name:  name,
+		dir:   filepath.Dir(name),
+		files: []string{fileKey(name)},
+	}
+}
+
+// include returns the state for the included file name, included at pos.
+func (st *includeState) include(pos string, name string, maxDepth int) (*includeState, error) {
+	child := &includeState{
+		name:  name,
+		dir:   filepath.Dir(name),
+		stack: append(slices.Clone(st.stack), pos),
+		files: append(slices.Clone(st.files), fileKey(name)),
+		cp:    st.cp,
+	}
+	if slices.Contains(st.files, fileKey(name)) {
+		return nil, &IncludeError{Stack: child.stack, Err: fmt.Errorf("%w: %s", errIncludeCycle, name)}
+	}
+	if len(child.stack) > maxDepth {
+		return nil, &IncludeError{Stack: child.stack, Err: fmt.Errorf("%w: %d", errIncludeDepth, maxDepth)}
+	}
+	return child, nil
+}
+
+// fileKey returns the key that identifies the file.
+func fileKey(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return filepath.Clean(name)
+}
+
+// includeSource parses the file as senddat source and writes the result to w.
+func (p *Parser) includeSource(w io.Writer, pos scanner.Position, filename string, st *includeState) error {
+	f, name, err := p.open(st.dir, filename)
+	if err != nil {
+		return fmt.Errorf("error including file '%s' at %v: %w", filename, pos, err)
+	}
+	defer f.Close()
+	child, err := st.include(pos.String(), name, p.opts.MaxIncludeDepth)
+	if err != nil {
+		return err
+	}
+	if err := p.parse(w, f, child); err != nil {
+		if ie := (*IncludeError)(nil); errors.As(err, &ie) {
+			return err
+		}
+		return &IncludeError{Stack: child.stack, Err: err}
+	}
+	return nil
+}
+
+// open opens the included file.  The relative name is resolved against the
+// directory dir of the including file, and then against the include path.
+// It returns the name of the opened file.
+func (p *Parser) open(dir, name string) (io.ReadCloser, string, error) {
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = []string{filepath.Join(dir, name)}
+		for _, inc := range p.opts.IncludePath {
+			candidates = append(candidates, filepath.Join(inc, name))
+		}
+	}
+	var firstErr error
+	for _, c := range candidates {
+		f, err := p.openFile(c)
+		if err == nil {
+			return f, c, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, c, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, name, firstErr
+}
+
+// openFile opens the file from the parser filesystem.  The paths in the
+// fs.FS are relative to its root, so the absolute paths, and the paths that
+// go above the root, are rejected.
+func (p *Parser) openFile(name string) (io.ReadCloser, error) {
+	if p.opts.FS == nil {
+		return os.Open(name)
+	}
+	if filepath.IsAbs(name) || strings.HasPrefix(filepath.ToSlash(name), "/") {
+		return nil, fmt.Errorf("absolute path %q is not supported with the filesystem of the parser, use the path relative to its root: %w", name, fs.ErrInvalid)
+	}
+	fsName := filepath.ToSlash(filepath.Clean(name))
+	if !fs.ValidPath(fsName) {
+		return nil, fmt.Errorf("path %q is outside of the filesystem of the parser: %w", name, fs.ErrInvalid)
+	}
+	return p.opts.FS.Open(fsName)
+}
@@ -0,0 +1,112 @@
+package senddat
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParser_include(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sub/part.dat":  {Data: []byte("\"A\"\n@raw.bin\n@@inner.dat\n")},
+		"sub/raw.bin":   {Data: []byte("R")},
+		"sub/inner.dat": {Data: []byte(`"I"`)},
+		"lib/lib.dat":   {Data: []byte(`"L"`)},
+		"cyc/a.dat":     {Data: []byte("@@b.dat\n")},
+		"cyc/b.dat":     {Data: []byte("\"B\"\n@@a.dat\n")},
+		"bad/x.dat":     {Data: []byte("\"X\"\nFOO\n")},
+		"deep/1.dat":    {Data: []byte("@@2.dat\n")},
+		"deep/2.dat":    {Data: []byte("@@3.dat\n")},
+		"deep/3.dat":    {Data: []byte(`"3"`)},
+	}
+	tests := []struct {
+		name     string
+		src      string
+		maxDepth int
+		want     string
+		wantErr  error
+		errText  string
+	}{
+		{"relative to the including file", "@@sub/part.dat\n", 0, "ARI", nil, ""},
+		{"include path", "@@lib.dat\n", 0, "L", nil, ""},
+		{"cycle", "@@cyc/a.dat\n", 0, "", errIncludeCycle, "(included from cyc/b.dat:2:1 <- cyc/a.dat:1:1 <- <input>:1:1)"},
+		{"max depth", "@@deep/1.dat\n", 2, "", errIncludeDepth, ""},
+		{"depth within limit", "@@deep/1.dat\n", 3, "3", nil, ""},
+		{"error in the included file", "@@bad/x.dat\n", 0, "", nil, "unknown identifier: FOO at line 2, pos bad/x.dat:2:4 (included from <input>:1:1)"},
+		{"missing", "@@none.dat\n", 0, "", os.ErrNotExist, ""},
+		{"absolute path", "@@/sub/inner.dat\n", 0, "", fs.ErrInvalid, `absolute path "/sub/inner.dat" is not supported`},
+		{"outside of the filesystem", "@@sub/../../inner.dat\n", 0, "", fs.ErrInvalid, "outside of the filesystem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(Options{
+				Output:          io.Discard,
+				Sleep:           func(time.Duration) {},
+				FS:              fsys,
+				IncludePath:     []string{"lib"},
+				MaxIncludeDepth: tt.maxDepth,
+			})
+			got, err := p.ParseString(tt.src)
+			if tt.wantErr == nil && tt.errText == "" {
+				if err != nil {
+					t.Fatalf("ParseString() error = %v", err)
+				}
+				assert.Equal(t, tt.want, string(got))
+				return
+			}
+			if err == nil {
+				t.Fatal("ParseString() expected error")
+			}
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+			if tt.errText != "" {
+				assert.Contains(t, err.Error(), tt.errText)
+			}
+		})
+	}
+}
+
+func TestParser_includeNamed(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"jobs/main.dat":         "\"M\"\n@@parts/part.dat\n#logo.png\n",
+		"jobs/parts/part.dat":   "@header.prn\n",
+		"jobs/parts/header.prn": "H",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestPNG(t, filepath.Join(dir, "jobs"), "logo.png")
+
+	f, err := os.Open(filepath.Join(dir, "jobs", "main.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out strings.Builder
+	if err := testParser.Parse(&out, f); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	assert.True(t, strings.HasPrefix(out.String(), "MH\x1dv0"), "got %q", out.String())
+}
+
+func TestIncludeError(t *testing.T) {
+	err := &IncludeError{Stack: []string{"main.dat:1:1", "sub.dat:5:1"}, Err: io.ErrUnexpectedEOF}
+	assert.Equal(t, "unexpected EOF (included from sub.dat:5:1 <- main.dat:1:1)", err.Error())
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
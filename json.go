@@ -0,0 +1,110 @@
+package senddat
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ByteEncoding is the encoding of binary data in the JSON output.
+type ByteEncoding int
+
+const (
+	EncodeBase64 ByteEncoding = iota
+	EncodeHex
+)
+
+func (enc ByteEncoding) String() string {
+	switch enc {
+	case EncodeBase64:
+		return "base64"
+	case EncodeHex:
+		return "hex"
+	}
+	return fmt.Sprintf("ByteEncoding(%d)", int(enc))
+}
+
+// Set implements flag.Value.
+func (enc *ByteEncoding) Set(s string) error {
+	switch strings.ToLower(s) {
+	case "base64":
+		*enc = EncodeBase64
+	case "hex":
+		*enc = EncodeHex
+	default:
+		return fmt.Errorf("unknown byte encoding: %q", s)
+	}
+	return nil
+}
+
+func (enc ByteEncoding) encode(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if enc == EncodeHex {
+		return hex.EncodeToString(b)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// JSONEntry is the JSON representation of the [Entry].  Binary fields are
+// encoded as base64 or hex strings.
+type JSONEntry struct {
+	// Offset is the position of the entry in the stream.
+	Offset int `json:"offset"`
+	// Length is the number of bytes of the entry in the stream.
+	Length int `json:"length"`
+	// Type is "command" or "data".
+	Type string `json:"type"`
+	// Name is the command name.
+	Name string `json:"name,omitempty"`
+	// Prefix is the command prefix bytes.
+	Prefix string `json:"prefix,omitempty"`
+	// Args are the command arguments by name.
+	Args map[string]uint8 `json:"args,omitempty"`
+	// Function is the name of the command function, i.e. for GS ( k.
+	Function string `json:"function,omitempty"`
+	// FunctionArgs are the function parameters by name.
+	FunctionArgs map[string]uint8 `json:"function_args,omitempty"`
+	// Payload is the command payload.
+	Payload string `json:"payload,omitempty"`
+	// Data is the raw data.
+	Data string `json:"data,omitempty"`
+}
+
+// JSON returns the JSON representation of the entry, with the binary data
+// encoded with enc.
+func (e Entry) JSON(enc ByteEncoding) JSONEntry {
+	je := JSONEntry{
+		Offset: e.Offset,
+		Length: len(e.Bytes()),
+	}
+	if !e.IsCommand() {
+		je.Type = "data"
+		je.Data = enc.encode(e.Data)
+		return je
+	}
+	je.Type = "command"
+	je.Name = e.Spec.Name
+	je.Prefix = enc.encode(e.Spec.Prefix)
+	if len(e.Args) > 0 {
+		if args, err := e.Spec.ArgValues(e.Args); err == nil {
+			je.Args = args
+		}
+	}
+	if e.Sub != nil {
+		je.Function = e.Sub.Name
+		if args := e.Sub.ArgValues(e.Payload); len(args) > 0 {
+			je.FunctionArgs = args
+		}
+	}
+	je.Payload = enc.encode(e.Payload)
+	return je
+}
+
+// MarshalJSON implements json.Marshaler, binary data is base64 encoded.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.JSON(EncodeBase64))
+}
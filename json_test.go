@@ -0,0 +1,62 @@
+package senddat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntry_JSON(t *testing.T) {
+	spec := &CommandSpec{Prefix: []byte{0x1B, '*'}, Name: "Bit Image Mode", ArgCount: 3, ArgNames: []string{"m", "nL", "nH"}}
+	tests := []struct {
+		name  string
+		entry Entry
+		enc   ByteEncoding
+		want  JSONEntry
+	}{
+		{
+			name:  "command hex",
+			entry: Entry{Offset: 5, Spec: spec, Args: []byte{0, 2, 0}, Payload: []byte{0xFF, 0x01}},
+			enc:   EncodeHex,
+			want: JSONEntry{
+				Offset:  5,
+				Length:  7,
+				Type:    "command",
+				Name:    "Bit Image Mode",
+				Prefix:  "1b2a",
+				Args:    map[string]uint8{"m": 0, "nL": 2, "nH": 0},
+				Payload: "ff01",
+			},
+		},
+		{
+			name:  "data base64",
+			entry: Entry{Offset: 1, Data: []byte("abc")},
+			enc:   EncodeBase64,
+			want:  JSONEntry{Offset: 1, Length: 3, Type: "data", Data: "YWJj"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.entry.JSON(tt.enc))
+		})
+	}
+}
+
+func TestEntry_MarshalJSON(t *testing.T) {
+	e := Entry{Offset: 3, Spec: &CommandSpec{Prefix: []byte{0x1B, 'J'}, Name: "Feed", ArgCount: 1, ArgNames: []string{"n"}}, Args: []byte{4}}
+	got, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, `{"offset":3,"length":3,"type":"command","name":"Feed","prefix":"G0o=","args":{"n":4}}`, string(got))
+}
+
+func TestByteEncoding_Set(t *testing.T) {
+	var enc ByteEncoding
+	assert.NoError(t, enc.Set("HEX"))
+	assert.Equal(t, EncodeHex, enc)
+	assert.NoError(t, enc.Set("base64"))
+	assert.Equal(t, EncodeBase64, enc)
+	assert.Error(t, enc.Set("base32"))
+}
@@ -6,9 +6,14 @@ import (
 	_ "embed"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"os"
 	"strings"
 	"text/scanner"
+	"time"
+
+	"golang.org/x/text/language"
 )
 
 // ControlCode represents a control code used in the ESC/P, ESC/POS, and ESC/P2
@@ -77,39 +82,181 @@ func (ew *errWriter) Fprintf(format string, args ...any) {
 	ew.N += n
 }
 
+// Options are the parser options.  Zero value fields are set to defaults.
+type Options struct {
+	// Output receives the messages of the "!" and "." commands, default is
+	// os.Stderr.
+	Output io.Writer
+	// Input is the input for the key input command, the command reads one
+	// line from it.  If it is nil, the command does not wait.
+	Input io.Reader
+	// Sleep is called for the "*" delays, default is time.Sleep.
+	Sleep func(time.Duration)
+	// FS is the filesystem for the included files and images.  If it is
+	// nil, files are opened from the operating system filesystem, relative
+	// to the current directory.  If it is set, the paths are relative to its
+	// root, and the absolute paths, or the paths that go above the root, are
+	// errors.
+	FS fs.FS
+	// IncludePath is the list of directories to search for the included
+	// files and images that are not found in the directory of the including
+	// file.
+	IncludePath []string
+	// MaxIncludeDepth is the maximum nesting of the "@@" source includes,
+	// default is DefaultMaxIncludeDepth.
+	MaxIncludeDepth int
+	// Logger is the logger, default is slog.Default().
+	Logger *slog.Logger
+	// Locale is the locale for the number formatting in templates, if it is
+	// not set, numbers are formatted without the thousands separators.
+	Locale language.Tag
+	// CodePage is the code page name, i.e. "PC866", to transcode the string
+	// literals to.  The code page follows the ESC t n commands in the source.
+	// If it is empty, strings are output in UTF-8, unless the source selects
+	// the code page with the "%" command.  AutoCodePage switches the code
+	// page for each run of characters from CodePages.
+	CodePage string
+	// CodePages are the code pages of the printer with their ESC t numbers,
+	// default is StandardCodePages.
+	CodePages CodePages
+	// Image are the image options for the "#" command, if the command does
+	// not override them.  The fields that are not set default to
+	// DefaultImageOptions.
+	Image ImageOptions
+	// Profile is the printer profile.  If it is set, CodePages default to the
+	// code pages of the printer, and images are scaled down to the paper
+	// width, unless Image.MaxWidth is set.
+	Profile *Profile
+	// Env are the environment variables that the templates can read with
+	// "env".  The name that ends with "*" is a prefix, i.e. "SENDDAT_*", and
+	// "*" allows all variables.  If it is empty, "env" is disabled.
+	Env []string
+}
+
+// Parser parses the senddat source.  It is safe to use the Parser
+// concurrently.
+type Parser struct {
+	opts Options
+}
+
+// NewParser returns the parser with the options.
+func NewParser(opts Options) *Parser {
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+	if opts.Sleep == nil {
+		opts.Sleep = time.Sleep
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.Image.Threshold == 0 {
+		// the other defaults are the zero values.
+		opts.Image.Threshold = DefaultImageOptions.Threshold
+	}
+	if opts.Profile != nil {
+		if opts.CodePages == nil {
+			opts.CodePages = opts.Profile.CodePageList()
+		}
+		if opts.Image.MaxWidth == 0 {
+			opts.Image.MaxWidth = opts.Profile.PaperWidth
+		}
+	}
+	if opts.CodePages == nil {
+		opts.CodePages = StandardCodePages
+	}
+	if opts.MaxIncludeDepth <= 0 {
+		opts.MaxIncludeDepth = DefaultMaxIncludeDepth
+	}
+	return &Parser{opts: opts}
+}
+
+// Parse parses the senddat source from r with the default options, and
+// writes the result to w.
 func Parse(w io.Writer, r io.Reader) error {
+	return NewParser(Options{}).Parse(w, r)
+}
+
+// Parse parses the senddat source from r and writes the result to w.  If r
+// has the Name method, as *os.File does, the relative includes are resolved
+// against the directory of the named file, otherwise against the current
+// directory.
+func (p *Parser) Parse(w io.Writer, r io.Reader) error {
+	return p.parseRoot(w, r, newIncludeState(r))
+}
+
+// parseRoot parses the top level source.
+func (p *Parser) parseRoot(w io.Writer, r io.Reader, st *includeState) error {
+	// the commands of the printer are followed to track the code page.
+	cp, err := newCodePageState(p.opts.CodePages, p.opts.CodePage, p.commandSpecs())
+	if err != nil {
+		return err
+	}
+	st.cp = cp
 
 	var bw = bufio.NewWriter(w)
 	defer bw.Flush()
 
+	if err := p.parse(bw, r, st); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	return nil
+}
+
+// commandSpecs returns the commands of the printer.
+func (p *Parser) commandSpecs() []CommandSpec {
+	if p.opts.Profile != nil {
+		return p.opts.Profile.CommandSpecs()
+	}
+	return GenericCommandSpecs
+}
+
+// parse parses the source from r, and writes the result to the buffered
+// writer bw.  It is called recursively for the source includes.
+func (p *Parser) parse(bw io.Writer, r io.Reader, st *includeState) error {
+	lg := p.opts.Logger
+
 	var ew = errWriter{Writer: bw}
 
 	var s scanner.Scanner
 	s.Init(r)
+	s.Filename = st.name
 	s.Mode = scanner.ScanIdents | scanner.ScanStrings | scanner.ScanInts | scanner.ScanComments | scanner.ScanRawStrings
 	s.Error = func(s *scanner.Scanner, msg string) {
-		slog.Error("scanner", "error", msg, "line", s.Line, "pos", s.Pos())
+		lg.Error("scanner", "error", msg, "line", s.Line, "pos", s.Pos())
 	}
 	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
 		t := s.TokenText()
-		lg := slog.With("line", s.Line, "pos", s.Pos(), "value", t)
+		lg := lg.With("line", s.Line, "pos", s.Pos(), "value", t)
 		switch tok {
 		case scanner.Ident:
 			lg.Debug("identifier")
 			t := s.TokenText()
 			if code, ok := tokenMap[t]; ok {
 				ew.Write([]byte{byte(code)})
+				st.cp.observe([]byte{byte(code)})
 			} else {
 				return fmt.Errorf("unknown identifier: %s at line %d, pos %v", t, s.Line, s.Pos())
 			}
 		case scanner.String:
 			lg.Debug("string")
-			text := strings.Trim(t, `"`)
-			ew.Write([]byte(text))
+			text, err := st.cp.encode(strings.Trim(t, `"`))
+			if err != nil {
+				return fmt.Errorf("%w at line %d, pos %v", err, s.Line, s.Pos())
+			}
+			ew.Write(text)
+			st.cp.observe(text)
 		case scanner.RawString:
 			lg.Debug("raw string")
-			text := strings.Trim(t, "`")
-			ew.Write([]byte(text))
+			text, err := st.cp.encode(strings.Trim(t, "`"))
+			if err != nil {
+				return fmt.Errorf("%w at line %d, pos %v", err, s.Line, s.Pos())
+			}
+			ew.Write(text)
+			st.cp.observe(text)
 		case scanner.Int:
 			lg.Debug("integer")
 			b, err := atob(t)
@@ -117,12 +264,15 @@ func Parse(w io.Writer, r io.Reader) error {
 				return fmt.Errorf("invalid integer: %s at line %d, pos %v", t, s.Line, s.Pos())
 			}
 			ew.Write([]byte{b})
+			st.cp.observe([]byte{b})
 		case scanner.Char:
 			lg.Info("char", "value", t, "line", s.Line, "pos", s.Pos())
 		case scanner.Comment:
 			lg.Debug("comment", "value", t, "line", s.Line, "pos", s.Pos())
-		case sdDelayMs, sdKeyInput, sdPrint, sdComment, sdxInclude, sdxImage: // senddat command
-			if err := senddatCommand(w, &s, tok); err != nil {
+		case sdDelayMs, sdKeyInput, sdPrint, sdComment, sdxInclude, sdxImage, sdxCodePage: // senddat command
+			// senddat commands write to the buffered writer to keep the
+			// output in order.
+			if err := p.command(bw, &s, tok, st); err != nil {
 				return err
 			}
 		default:
@@ -163,8 +313,13 @@ func atob(t string) (byte, error) {
 
 // ParseString parses the string, like 'ESC "@"' and returns bytes.
 func ParseString(s string) ([]byte, error) {
+	return NewParser(Options{}).ParseString(s)
+}
+
+// ParseString parses the string, like 'ESC "@"' and returns bytes.
+func (p *Parser) ParseString(s string) ([]byte, error) {
 	var buf bytes.Buffer
-	if err := Parse(&buf, strings.NewReader(s)); err != nil {
+	if err := p.Parse(&buf, strings.NewReader(s)); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -3,10 +3,17 @@ package senddat
 import (
 	"bytes"
 	"embed"
+	"fmt"
 	"io"
+	"io/fs"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 const (
@@ -45,7 +52,7 @@ func toPRN(t *testing.T, fs embed.FS, name string) []byte {
 	t.Helper()
 	data := loadFile(t, fs, name)
 	var buf bytes.Buffer
-	if err := Parse(&buf, bytes.NewReader(data)); err != nil {
+	if err := testParser.Parse(&buf, bytes.NewReader(data)); err != nil {
 		t.Fatalf("Failed to parse file %s: %v", name, err)
 	}
 	return buf.Bytes()
@@ -137,7 +144,7 @@ func TestParse(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			w := &bytes.Buffer{}
-			if err := Parse(w, tt.args.r); (err != nil) != tt.wantErr {
+			if err := testParser.Parse(w, tt.args.r); (err != nil) != tt.wantErr {
 				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
@@ -230,7 +237,7 @@ func TestParseString(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := ParseString(tt.args.s)
+			got, err := testParser.ParseString(tt.args.s)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ParseString() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -241,3 +248,41 @@ func TestParseString(t *testing.T) {
 		})
 	}
 }
+
+func TestParser_options(t *testing.T) {
+	fsys := fstest.MapFS{
+		"inc/logo.prn": &fstest.MapFile{Data: []byte("LOGO")},
+	}
+	// parsers with different options run concurrently.
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var (
+				msgs   bytes.Buffer
+				delays []time.Duration
+				out    bytes.Buffer
+			)
+			p := NewParser(Options{
+				Output:      &msgs,
+				Sleep:       func(d time.Duration) { delays = append(delays, d) },
+				FS:          fsys,
+				IncludePath: []string{"inc"},
+			})
+			src := fmt.Sprintf("!job %d\n*%d\n@logo.prn\nLF", i, i+1)
+			if err := p.Parse(&out, strings.NewReader(src)); err != nil {
+				t.Errorf("Parse() error = %v", err)
+				return
+			}
+			assert.Equal(t, fmt.Sprintf("job %d\n", i), msgs.String())
+			assert.Equal(t, []time.Duration{time.Duration(i+1) * time.Millisecond}, delays)
+			assert.Equal(t, "LOGO\n", out.String())
+		}()
+	}
+	wg.Wait()
+
+	p := NewParser(Options{FS: fsys})
+	_, err := p.ParseString("@logo.prn\n")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,219 @@
+package senddat
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed profiles/*.yaml
+var profileFS embed.FS
+
+// Profile describes the capabilities of the printer model, so that the
+// parser, the image scaler, the decoder and the virtual printer agree on the
+// hardware.
+type Profile struct {
+	// Name is the profile name, i.e. "tm-m30ii".
+	Name string `yaml:"-"`
+	// Model is the printer model, i.e. "Epson TM-m30II".
+	Model string `yaml:"model"`
+	// PaperWidth is the printable width in dots.
+	PaperWidth int `yaml:"paper_width"`
+	// DPI is the print resolution in dots per inch.
+	DPI int `yaml:"dpi"`
+	// Columns is the number of characters per line for each font.
+	Columns ProfileColumns `yaml:"columns"`
+	// CodePages are the code pages that the printer has, with their ESC t
+	// numbers, in the order of preference for the automatic switching.
+	CodePages []ProfileCodePage `yaml:"code_pages"`
+	// Cutter is true if the printer has the autocutter.
+	Cutter bool `yaml:"cutter"`
+	// Drawer is true if the printer has the cash drawer kick-out connector.
+	Drawer bool `yaml:"drawer"`
+	// Commands is the command CSV of the printer, the name of the embedded
+	// driver, i.e. "xprinter", or the path to the CSV file, relative to the
+	// profile file.
+	Commands string `yaml:"commands"`
+
+	codePages CodePages
+	specs     []CommandSpec
+}
+
+// ProfileColumns is the number of characters per line of font A and font B.
+type ProfileColumns struct {
+	FontA int `yaml:"font_a"`
+	FontB int `yaml:"font_b"`
+}
+
+// ProfileCodePage is the code page name and its ESC t number on the printer.
+type ProfileCodePage struct {
+	Name  string `yaml:"name"`
+	Table byte   `yaml:"table"`
+}
+
+// ErrUnsupported is returned by [Profile.Check] for the commands that the
+// printer does not support.
+var ErrUnsupported = errors.New("not supported by the printer")
+
+// Profiles returns the names of the embedded profiles.
+func Profiles() []string {
+	files, _ := fs.Glob(profileFS, "profiles/*.yaml")
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = strings.TrimSuffix(path.Base(file), ".yaml")
+	}
+	slices.Sort(names)
+	return names
+}
+
+// LoadProfile loads the embedded profile by its name, i.e. "tm-m30ii", or the
+// profile file, if the name has the .yaml or .yml extension.
+func LoadProfile(name string) (*Profile, error) {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ReadProfile(f)
+	}
+	data, err := profileFS.ReadFile(path.Join("profiles", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown profile: %s, have: %s", name, strings.Join(Profiles(), ", "))
+	}
+	return ReadProfile(namedReader{Reader: bytes.NewReader(data), name: name + ".yaml"})
+}
+
+// ReadProfile reads the YAML profile from r.  If r has the Name method, as
+// *os.File does, the profile is named after the file, and the relative
+// command CSV path is resolved against its directory.
+func ReadProfile(r io.Reader) (*Profile, error) {
+	var pr Profile
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&pr); err != nil {
+		return nil, fmt.Errorf("invalid profile: %w", err)
+	}
+	var dir string
+	if nr, ok := r.(interface{ Name() string }); ok {
+		pr.Name = strings.TrimSuffix(filepath.Base(nr.Name()), filepath.Ext(nr.Name()))
+		dir = filepath.Dir(nr.Name())
+	}
+	if err := pr.init(dir); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", pr.Name, err)
+	}
+	return &pr, nil
+}
+
+// init validates the profile, and resolves the code pages and commands.
+func (pr *Profile) init(dir string) error {
+	if pr.PaperWidth <= 0 {
+		return errors.New("paper_width must be positive")
+	}
+	if pr.Columns.FontA < 0 || pr.Columns.FontB < 0 {
+		return errors.New("columns must not be negative")
+	}
+	pr.codePages = make(CodePages, 0, len(pr.CodePages))
+	for _, pcp := range pr.CodePages {
+		cp, err := StandardCodePages.Lookup(pcp.Name)
+		if err != nil {
+			return err
+		}
+		if _, ok := pr.codePages.Table(pcp.Table); ok {
+			return fmt.Errorf("duplicate code page table %d", pcp.Table)
+		}
+		// printers of other vendors have their own table numbers.
+		cp.Table = pcp.Table
+		pr.codePages = append(pr.codePages, cp)
+	}
+	commands := pr.Commands
+	switch {
+	case commands == "":
+		pr.specs = GenericCommandSpecs
+		return nil
+	case filepath.Ext(commands) == ".csv" && !filepath.IsAbs(commands):
+		commands = filepath.Join(dir, commands)
+	}
+	specs, err := LoadDriver(commands)
+	if err != nil {
+		return err
+	}
+	pr.specs = specs
+	return nil
+}
+
+// CodePageList returns the code pages of the printer with their ESC t
+// numbers.
+func (pr *Profile) CodePageList() CodePages {
+	return pr.codePages
+}
+
+// CommandSpecs returns the command specs of the printer, or
+// GenericCommandSpecs, if the profile does not set the commands.
+func (pr *Profile) CommandSpecs() []CommandSpec {
+	return pr.specs
+}
+
+// VirtualPrinter returns the virtual printer with the paper width, fonts and
+// code pages of the printer.
+func (pr *Profile) VirtualPrinter() *VirtualPrinter {
+	vp := NewVirtualPrinter(pr.PaperWidth)
+	for i, cols := range []int{pr.Columns.FontA, pr.Columns.FontB} {
+		if cols > 0 {
+			vp.cells[i].w = pr.PaperWidth / cols
+		}
+	}
+	vp.CodePages = pr.codePages
+	vp.reset()
+	return vp
+}
+
+// Check checks that the printer supports the command of the entry: paper cut
+// needs the cutter, drawer kick-out pulse needs the drawer, the code page
+// must be in the profile, and bit images must fit the paper.  It returns nil
+// for data and unknown commands.
+func (pr *Profile) Check(e Entry) error {
+	if !e.IsCommand() {
+		return nil
+	}
+	arg := func(i int) int {
+		if i < len(e.Args) {
+			return int(e.Args[i])
+		}
+		return 0
+	}
+	switch string(e.Spec.Prefix) {
+	case "\x1dV", "\x1bi", "\x1bm", "\x1d(V": // GS V, ESC i, ESC m, GS ( V
+		if !pr.Cutter {
+			return fmt.Errorf("%w: paper cut, %s has no cutter", ErrUnsupported, pr.Model)
+		}
+	case "\x1bp", "\x10\x14": // ESC p, DLE DC4
+		if !pr.Drawer {
+			return fmt.Errorf("%w: drawer kick-out, %s has no drawer connector", ErrUnsupported, pr.Model)
+		}
+	case "\x1bt": // ESC t
+		if _, ok := pr.codePages.Table(byte(arg(0))); !ok {
+			return fmt.Errorf("%w: code table %d", ErrUnsupported, arg(0))
+		}
+	case "\x1b*": // ESC *
+		if w := arg(1) + arg(2)*256; w > pr.PaperWidth {
+			return fmt.Errorf("%w: bit image %d dots wide, paper is %d dots", ErrUnsupported, w, pr.PaperWidth)
+		}
+	case "\x1dv0": // GS v 0
+		if w := (arg(1) + arg(2)*256) * 8; w > pr.PaperWidth {
+			return fmt.Errorf("%w: raster image %d dots wide, paper is %d dots", ErrUnsupported, w, pr.PaperWidth)
+		}
+	}
+	return nil
+}
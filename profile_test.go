@@ -0,0 +1,183 @@
+package senddat
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadProfile_embedded(t *testing.T) {
+	names := Profiles()
+	assert.Contains(t, names, "tm-m30ii")
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			pr, err := LoadProfile(name)
+			if err != nil {
+				t.Fatalf("LoadProfile() error = %v", err)
+			}
+			assert.Equal(t, name, pr.Name)
+			assert.NotEmpty(t, pr.CommandSpecs())
+			assert.Len(t, pr.CodePageList(), len(pr.CodePages))
+		})
+	}
+}
+
+func TestReadProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			yaml: "model: Test\npaper_width: 384\ncode_pages:\n  - {name: PC866, table: 7}\n",
+		},
+		{
+			name:    "unknown field",
+			yaml:    "model: Test\npaper_width: 384\ncolour: red\n",
+			wantErr: "field colour not found",
+		},
+		{
+			name:    "no paper width",
+			yaml:    "model: Test\n",
+			wantErr: "paper_width must be positive",
+		},
+		{
+			name:    "unknown code page",
+			yaml:    "paper_width: 384\ncode_pages:\n  - {name: EBCDIC, table: 0}\n",
+			wantErr: "unknown code page: EBCDIC",
+		},
+		{
+			name:    "duplicate table",
+			yaml:    "paper_width: 384\ncode_pages:\n  - {name: PC437, table: 0}\n  - {name: PC850, table: 0}\n",
+			wantErr: "duplicate code page table 0",
+		},
+		{
+			name:    "unknown driver",
+			yaml:    "paper_width: 384\ncommands: nope\n",
+			wantErr: "unknown driver: nope",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, err := ReadProfile(strings.NewReader(tt.yaml))
+			if tt.wantErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			cp, ok := pr.CodePageList().Table(7)
+			if assert.True(t, ok) {
+				assert.Equal(t, "PC866", cp.Name)
+			}
+		})
+	}
+}
+
+func TestLoadProfile_file(t *testing.T) {
+	dir := t.TempDir()
+	csv := "prefix,name,arg_names,payload_formula\n\"ESC \"\"@\"\"\",Initialize,,\n"
+	if err := os.WriteFile(filepath.Join(dir, "cmds.csv"), []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "my-printer.yaml")
+	if err := os.WriteFile(filename, []byte("paper_width: 384\ncommands: cmds.csv\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pr, err := LoadProfile(filename)
+	if err != nil {
+		t.Fatalf("LoadProfile() error = %v", err)
+	}
+	assert.Equal(t, "my-printer", pr.Name)
+	if assert.Len(t, pr.CommandSpecs(), 1) {
+		assert.Equal(t, "Initialize", pr.CommandSpecs()[0].Name)
+	}
+}
+
+func TestProfile_Check(t *testing.T) {
+	pr, err := LoadProfile("xp-58")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		src     string
+		wantErr bool
+	}{
+		{"text", `"Hello" LF`, false},
+		{"supported code table", `ESC "t" 16`, false},
+		{"unsupported code table", `ESC "t" 17`, true},
+		{"cut without cutter", `GS "V" 0`, true},
+		{"drawer", `ESC "p" 0 25 250`, false},
+		{"image fits", `GS "v0" 0 48 0 1 0` + strings.Repeat(" 0", 48), false},
+		{"image too wide", `GS "v0" 0 49 0 1 0` + strings.Repeat(" 0", 49), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prn, err := testParser.ParseString(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := Decode(bytes.NewReader(prn), pr.CommandSpecs())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var errs []error
+			for _, e := range entries {
+				errs = append(errs, pr.Check(e))
+			}
+			err = errors.Join(errs...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				assert.ErrorIs(t, err, ErrUnsupported)
+			}
+		})
+	}
+}
+
+func TestProfile_VirtualPrinter(t *testing.T) {
+	pr, err := LoadProfile("xp-58")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp := pr.VirtualPrinter()
+	assert.Equal(t, [2]cell{{12, 24}, {9, 17}}, vp.cells)
+	vp.Print(Entry{Data: []byte(strings.Repeat("A", 33) + "\n")})
+	img := vp.Image()
+	assert.Equal(t, 384, img.Bounds().Dx())
+	// 32 columns fit the line, the 33rd character is on the next line.
+	assert.Equal(t, 2*defaultLineSpacing, img.Bounds().Dy())
+}
+
+func TestParse_profile(t *testing.T) {
+	pr, err := LoadProfile("xp-58")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewParser(Options{Output: testParser.opts.Output, Profile: pr, CodePage: AutoCodePage})
+	if _, err := p.ParseString(`"Молоко"`); err == nil {
+		t.Error("ParseString() expected error for the code page that the printer does not have")
+	}
+	got, err := p.ParseString(`"5€"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte{'5', 0x1B, 't', 16, 0x80, 0x1B, 't', 0}, got)
+
+	t.Run("image is scaled to the paper width", func(t *testing.T) {
+		b := ToBitmap(image.NewGray(image.Rect(0, 0, 1000, 10)), p.opts.Image)
+		assert.Equal(t, 384, b.Width)
+	})
+}
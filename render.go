@@ -0,0 +1,391 @@
+package senddat
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// DefaultPaperWidth is the printable width of 80 mm paper at 203 dpi in
+	// dots.
+	DefaultPaperWidth = 576
+	// defaultLineSpacing is the line spacing in dots, set by ESC 2.
+	defaultLineSpacing = 30
+	// tabWidth is the distance between the default tab positions in
+	// characters.
+	tabWidth = 8
+	// cutMargin is the space left around the cut mark.
+	cutMargin = 8
+)
+
+// cell is the character cell size in dots.
+type cell struct {
+	w, h int
+}
+
+// fontCells are the character cell sizes for font A and font B.
+var fontCells = [...]cell{{12, 24}, {9, 17}}
+
+// lineItem is a character or a bit image in the line buffer.
+type lineItem struct {
+	x         int // horizontal position in the line
+	mask      *image.Alpha
+	underline int // underline thickness in dots
+}
+
+// glyphKey identifies the scaled glyph in the glyph cache.
+type glyphKey struct {
+	r    rune
+	bold bool
+	size cell
+}
+
+// VirtualPrinter renders the decoded ESC/POS entries into an image of the
+// paper roll, as a printer in the standard mode would print it.  Call Print
+// for every entry, and then Image to get the result.
+type VirtualPrinter struct {
+	width  int
+	canvas *image.Gray
+	y      int     // current vertical position on the paper
+	cells  [2]cell // character cells of font A and font B
+
+	// print mode
+	font      int
+	wmul      int
+	hmul      int
+	bold      bool
+	underline int
+	align     Alignment
+	spacing   int
+	codePage  CodePage
+
+	// CodePages are the code pages that ESC t selects, default is
+	// StandardCodePages.
+	CodePages CodePages
+
+	line   []lineItem // line buffer
+	lineX  int        // next character position in the line buffer
+	glyphs map[glyphKey]*image.Alpha
+}
+
+// NewVirtualPrinter creates a virtual printer with the paper width in dots.
+// If the width is zero, the DefaultPaperWidth is used.
+func NewVirtualPrinter(width int) *VirtualPrinter {
+	if width <= 0 {
+		width = DefaultPaperWidth
+	}
+	vp := &VirtualPrinter{
+		width:  width,
+		canvas: newPaper(width, 1024),
+		cells:  fontCells,
+		glyphs: make(map[glyphKey]*image.Alpha),
+
+		CodePages: StandardCodePages,
+	}
+	vp.reset()
+	return vp
+}
+
+// Render renders the entries on the virtual printer with the paper width in
+// dots, and returns the image.
+func Render(entries []Entry, width int) *image.Gray {
+	vp := NewVirtualPrinter(width)
+	for _, e := range entries {
+		vp.Print(e)
+	}
+	return vp.Image()
+}
+
+func newPaper(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	return img
+}
+
+// reset resets the print mode, as ESC @ does.
+func (vp *VirtualPrinter) reset() {
+	vp.font = 0
+	vp.wmul, vp.hmul = 1, 1
+	vp.bold = false
+	vp.underline = 0
+	vp.align = AlignLeft
+	vp.spacing = defaultLineSpacing
+	vp.selectCodePage(0)
+}
+
+// selectCodePage selects the code page by the ESC t table number.  Unknown
+// tables print the bytes as Latin-1.
+func (vp *VirtualPrinter) selectCodePage(n byte) {
+	cp, ok := vp.CodePages.Table(n)
+	if !ok {
+		cp = CodePage{Table: n}
+	}
+	vp.codePage = cp
+}
+
+// Image flushes the line buffer and returns the image of the printed paper.
+func (vp *VirtualPrinter) Image() *image.Gray {
+	if len(vp.line) > 0 {
+		vp.printLine(vp.lineFeed)
+	}
+	return vp.canvas.SubImage(image.Rect(0, 0, vp.width, max(vp.y, 1))).(*image.Gray)
+}
+
+// Print renders the entry.  Commands that do not affect the printed image are
+// ignored.
+func (vp *VirtualPrinter) Print(e Entry) {
+	if e.IsData() {
+		for _, b := range e.Data {
+			vp.printByte(b)
+		}
+		return
+	}
+	if e.Spec == nil {
+		return
+	}
+	arg := func(i int) int {
+		if i < len(e.Args) {
+			return int(e.Args[i])
+		}
+		return 0
+	}
+	switch string(e.Spec.Prefix) {
+	case "\n", "\r", "\t": // LF, CR, HT
+		vp.printByte(e.Spec.Prefix[0])
+	case "\x1b@": // ESC @
+		vp.line, vp.lineX = nil, 0
+		vp.reset()
+	case "\x1b!": // ESC !
+		n := arg(0)
+		vp.font = n & 1
+		vp.bold = n&0x08 != 0
+		vp.hmul = 1 + (n>>4)&1
+		vp.wmul = 1 + (n>>5)&1
+		vp.underline = (n >> 7) & 1
+	case "\x1dV": // GS V
+		vp.cut()
+	case "\x1d!": // GS !
+		vp.wmul = 1 + (arg(0)>>4)&7
+		vp.hmul = 1 + arg(0)&7
+	case "\x1bE", "\x1bG": // ESC E, ESC G
+		vp.bold = arg(0)&1 != 0
+	case "\x1b-": // ESC -
+		vp.underline = arg(0) & 3
+		if vp.underline > 2 {
+			vp.underline = 0
+		}
+	case "\x1bM": // ESC M
+		vp.font = arg(0) & 1
+	case "\x1ba": // ESC a
+		vp.align = Alignment(arg(0) & 3)
+	case "\x1b2": // ESC 2
+		vp.spacing = defaultLineSpacing
+	case "\x1b3": // ESC 3
+		vp.spacing = arg(0)
+	case "\x1bJ": // ESC J
+		vp.printLine(func(int) int { return arg(0) })
+	case "\x1bd": // ESC d
+		n := arg(0)
+		if n == 0 {
+			vp.printLine(func(h int) int { return h })
+			break
+		}
+		vp.printLine(vp.lineFeed)
+		vp.y += (n - 1) * vp.spacing
+	case "\x1bt": // ESC t
+		vp.selectCodePage(byte(arg(0)))
+	case "\x1b*": // ESC *
+		vp.bitImage(arg(0), e.Payload)
+	case "\x1dv0": // GS v 0
+		vp.rasterImage(arg(0), arg(1)+arg(2)*256, arg(3)+arg(4)*256, e.Payload)
+	}
+}
+
+// lineFeed returns the paper feed amount for the line of height h.
+func (vp *VirtualPrinter) lineFeed(h int) int {
+	return max(vp.spacing, h)
+}
+
+// printByte prints the character or executes the control character.
+func (vp *VirtualPrinter) printByte(b byte) {
+	switch {
+	case b == byte(bLF):
+		vp.printLine(vp.lineFeed)
+	case b == byte(bHT):
+		step := vp.cells[vp.font].w * tabWidth
+		vp.lineX = (vp.lineX/step + 1) * step
+	case b < byte(bSP):
+		// other control characters are not printed.
+	default:
+		vp.printChar(vp.codePage.Decode(b))
+	}
+}
+
+// printChar puts the character into the line buffer, printing the line if
+// the character does not fit.
+func (vp *VirtualPrinter) printChar(r rune) {
+	c := vp.cells[vp.font]
+	size := cell{c.w * vp.wmul, c.h * vp.hmul}
+	if vp.lineX+size.w > vp.width {
+		vp.printLine(vp.lineFeed)
+	}
+	vp.line = append(vp.line, lineItem{
+		x:         vp.lineX,
+		mask:      vp.glyph(r, size),
+		underline: vp.underline,
+	})
+	vp.lineX += size.w
+}
+
+// glyph returns the glyph mask of the rune, scaled to the cell size.
+func (vp *VirtualPrinter) glyph(r rune, size cell) *image.Alpha {
+	key := glyphKey{r: r, bold: vp.bold, size: size}
+	if m, ok := vp.glyphs[key]; ok {
+		return m
+	}
+	var face *basicfont.Face = inconsolata.Regular8x16
+	if vp.bold {
+		face = inconsolata.Bold8x16
+	}
+	src := image.NewAlpha(image.Rect(0, 0, face.Advance, face.Height))
+	if _, ok := face.GlyphAdvance(r); ok {
+		d := font.Drawer{Dst: src, Src: image.Opaque, Face: face, Dot: fixed.P(0, face.Ascent)}
+		d.DrawString(string(r))
+	} else {
+		missingGlyph(src)
+	}
+	m := image.NewAlpha(image.Rect(0, 0, size.w, size.h))
+	xdraw.NearestNeighbor.Scale(m, m.Bounds(), src, src.Bounds(), xdraw.Src, nil)
+	vp.glyphs[key] = m
+	return m
+}
+
+// missingGlyph draws the box for the character that the font does not have.
+func missingGlyph(m *image.Alpha) {
+	b := m.Bounds().Inset(1)
+	b.Min.Y += 3
+	for x := b.Min.X; x < b.Max.X; x++ {
+		m.SetAlpha(x, b.Min.Y, color.Alpha{A: 0xFF})
+		m.SetAlpha(x, b.Max.Y-1, color.Alpha{A: 0xFF})
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		m.SetAlpha(b.Min.X, y, color.Alpha{A: 0xFF})
+		m.SetAlpha(b.Max.X-1, y, color.Alpha{A: 0xFF})
+	}
+}
+
+// bitImage puts the ESC * bit image into the line buffer.
+func (vp *VirtualPrinter) bitImage(m int, data []byte) {
+	var dots, hscale, vscale int
+	switch m {
+	case 0:
+		dots, hscale, vscale = 8, 2, 3
+	case 1:
+		dots, hscale, vscale = 8, 1, 3
+	case 32:
+		dots, hscale, vscale = 24, 2, 1
+	case 33:
+		dots, hscale, vscale = 24, 1, 1
+	default:
+		return
+	}
+	bpc := dots / 8 // bytes per column
+	cols := len(data) / bpc
+	mask := image.NewAlpha(image.Rect(0, 0, cols*hscale, dots*vscale))
+	for x := range cols {
+		for y := range dots {
+			if data[x*bpc+y/8]&(0x80>>(y%8)) == 0 {
+				continue
+			}
+			r := image.Rect(x*hscale, y*vscale, (x+1)*hscale, (y+1)*vscale)
+			draw.Draw(mask, r, image.Opaque, image.Point{}, draw.Src)
+		}
+	}
+	vp.line = append(vp.line, lineItem{x: vp.lineX, mask: mask})
+	vp.lineX += mask.Rect.Dx()
+}
+
+// rasterImage prints the GS v 0 raster image, bytesPerRow wide and rows high.
+func (vp *VirtualPrinter) rasterImage(m int, bytesPerRow, rows int, data []byte) {
+	if len(vp.line) > 0 {
+		vp.printLine(vp.lineFeed)
+	}
+	hscale, vscale := 1+m&1, 1+(m>>1)&1
+	mask := image.NewAlpha(image.Rect(0, 0, bytesPerRow*8*hscale, rows*vscale))
+	for y := range rows {
+		for x := range bytesPerRow * 8 {
+			i := y*bytesPerRow + x/8
+			if i >= len(data) || data[i]&(0x80>>(x%8)) == 0 {
+				continue
+			}
+			r := image.Rect(x*hscale, y*vscale, (x+1)*hscale, (y+1)*vscale)
+			draw.Draw(mask, r, image.Opaque, image.Point{}, draw.Src)
+		}
+	}
+	vp.line = []lineItem{{mask: mask}}
+	vp.lineX = mask.Rect.Dx()
+	vp.printLine(func(h int) int { return h })
+}
+
+// printLine prints the line buffer at the current position, aligned
+// according to the justification, and advances the paper by the amount
+// returned by feed for the height of the tallest item in the line.
+func (vp *VirtualPrinter) printLine(feed func(h int) int) {
+	var h int
+	for _, it := range vp.line {
+		h = max(h, it.mask.Rect.Dy())
+	}
+	vp.grow(vp.y + h)
+	var offset int
+	switch vp.align {
+	case AlignCenter:
+		offset = (vp.width - vp.lineX) / 2
+	case AlignRight:
+		offset = vp.width - vp.lineX
+	}
+	offset = max(offset, 0)
+	for _, it := range vp.line {
+		b := it.mask.Rect
+		// items are aligned on the bottom line.
+		r := b.Add(image.Pt(offset+it.x, vp.y+h-b.Dy()))
+		draw.DrawMask(vp.canvas, r, image.Black, image.Point{}, it.mask, image.Point{}, draw.Over)
+		if it.underline > 0 {
+			ul := image.Rect(r.Min.X, r.Max.Y-it.underline, r.Max.X, r.Max.Y)
+			draw.Draw(vp.canvas, ul, image.Black, image.Point{}, draw.Src)
+		}
+	}
+	vp.line, vp.lineX = vp.line[:0], 0
+	vp.y += feed(h)
+}
+
+// cut prints the line buffer and draws the dashed cut line.
+func (vp *VirtualPrinter) cut() {
+	if len(vp.line) > 0 {
+		vp.printLine(vp.lineFeed)
+	}
+	vp.y += cutMargin
+	vp.grow(vp.y + 1)
+	for x := 0; x < vp.width; x += 8 {
+		for dx := range 4 {
+			vp.canvas.SetGray(x+dx, vp.y, color.Gray{})
+		}
+	}
+	vp.y += cutMargin
+}
+
+// grow grows the canvas so that it is at least h dots high.
+func (vp *VirtualPrinter) grow(h int) {
+	if h <= vp.canvas.Rect.Dy() {
+		return
+	}
+	paper := newPaper(vp.width, max(h, vp.canvas.Rect.Dy()*2))
+	draw.Draw(paper, vp.canvas.Rect, vp.canvas, image.Point{}, draw.Src)
+	vp.canvas = paper
+}
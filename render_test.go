@@ -0,0 +1,171 @@
+package senddat
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// renderDat parses the senddat source, decodes it and renders the result.
+func renderDat(t *testing.T, src string, width int) *image.Gray {
+	t.Helper()
+	prn, err := testParser.ParseString(src)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	entries, err := Decode(bytes.NewReader(prn), GenericCommandSpecs)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	return Render(entries, width)
+}
+
+// inkBounds returns the bounding rectangle of black dots in the image.
+func inkBounds(img *image.Gray) image.Rectangle {
+	var r image.Rectangle
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.GrayAt(x, y).Y < 128 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+func TestRender_text(t *testing.T) {
+	img := renderDat(t, `"ABC" LF`, 0)
+	assert.Equal(t, image.Rect(0, 0, DefaultPaperWidth, defaultLineSpacing), img.Bounds())
+	ink := inkBounds(img)
+	assert.False(t, ink.Empty())
+	assert.LessOrEqual(t, ink.Max.X, 3*fontCells[0].w)
+}
+
+func TestRender_fontB(t *testing.T) {
+	img := renderDat(t, `ESC "M" 1 "ABC" LF`, 0)
+	assert.LessOrEqual(t, inkBounds(img).Max.X, 3*fontCells[1].w)
+}
+
+func TestRender_justification(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		check func(t *testing.T, ink image.Rectangle)
+	}{
+		{
+			name: "center",
+			src:  `ESC "a" 1 "AB" LF`,
+			check: func(t *testing.T, ink image.Rectangle) {
+				assert.GreaterOrEqual(t, ink.Min.X, DefaultPaperWidth/2-fontCells[0].w)
+				assert.LessOrEqual(t, ink.Max.X, DefaultPaperWidth/2+fontCells[0].w)
+			},
+		},
+		{
+			name: "right",
+			src:  `ESC "a" 2 "AB" LF`,
+			check: func(t *testing.T, ink image.Rectangle) {
+				assert.GreaterOrEqual(t, ink.Min.X, DefaultPaperWidth-2*fontCells[0].w)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, inkBounds(renderDat(t, tt.src, 0)))
+		})
+	}
+}
+
+func TestRender_size(t *testing.T) {
+	img := renderDat(t, `GS "!" 0x11 "W" LF`, 0)
+	assert.Equal(t, 2*fontCells[0].h, img.Bounds().Dy())
+	ink := inkBounds(img)
+	assert.Greater(t, ink.Dx(), fontCells[0].w)
+	assert.Greater(t, ink.Dy(), fontCells[0].h)
+}
+
+func TestRender_printMode(t *testing.T) {
+	// ESC ! 0x80 is underline, every dot of the bottom row is printed.
+	img := renderDat(t, `ESC "!" 0x80 "  " LF`, 0)
+	for x := range 2 * fontCells[0].w {
+		assert.Equal(t, uint8(0), img.GrayAt(x, fontCells[0].h-1).Y)
+	}
+}
+
+func TestRender_emphasis(t *testing.T) {
+	count := func(img *image.Gray) (n int) {
+		for _, p := range img.Pix {
+			if p == 0 {
+				n++
+			}
+		}
+		return
+	}
+	normal := renderDat(t, `"HELLO" LF`, 0)
+	bold := renderDat(t, `ESC "E" 1 "HELLO" LF`, 0)
+	assert.Greater(t, count(bold), count(normal))
+}
+
+func TestRender_feed(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		wantH int
+	}{
+		{"ESC J", `"A" ESC "J" 100`, 100},
+		{"ESC d", `"A" ESC "d" 3`, 3 * defaultLineSpacing},
+		{"ESC 3", `ESC "3" 50 "A" LF LF`, 100},
+		{"wrap", `"` + strings.Repeat("A", DefaultPaperWidth/fontCells[0].w+1) + `" LF`, 2 * defaultLineSpacing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := renderDat(t, tt.src, 0)
+			assert.Equal(t, tt.wantH, img.Bounds().Dy())
+		})
+	}
+}
+
+func TestRender_cut(t *testing.T) {
+	img := renderDat(t, `GS "V" 66 0`, 0)
+	assert.Equal(t, 2*cutMargin, img.Bounds().Dy())
+	assert.Equal(t, uint8(0), img.GrayAt(0, cutMargin).Y)
+	assert.Equal(t, uint8(255), img.GrayAt(5, cutMargin).Y)
+}
+
+func TestRender_bitImage(t *testing.T) {
+	img := renderDat(t, `ESC "3" 24 ESC "*" 33 2 0 0xFF 0xFF 0xFF 0x00 0x00 0x01 LF`, 0)
+	assert.Equal(t, 24, img.Bounds().Dy())
+	for y := range 24 {
+		assert.Equal(t, uint8(0), img.GrayAt(0, y).Y, "y=%d", y)
+	}
+	assert.Equal(t, image.Rect(0, 0, 2, 24), inkBounds(img))
+}
+
+func TestRender_rasterImage(t *testing.T) {
+	b := NewBitmap(16, 3)
+	b.Set(0, 0, true)
+	b.Set(15, 2, true)
+	var prn bytes.Buffer
+	if err := WriteImage(&prn, b, ImageOptions{Align: AlignRight}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Decode(&prn, GenericCommandSpecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := Render(entries, 64)
+	assert.Equal(t, image.Rect(0, 0, 64, 3), img.Bounds())
+	assert.Equal(t, image.Rect(48, 0, 64, 3), inkBounds(img))
+}
+
+func TestRender_codePage(t *testing.T) {
+	// 0xE9 is "é" in WPC1252, and "щ" in PC866, that the font does not have.
+	latin := renderDat(t, `ESC "t" 16 0xE9 LF`, 0)
+	cyrillic := renderDat(t, `ESC "t" 17 0xE9 LF`, 0)
+	assert.NotEqual(t, latin.Pix, cyrillic.Pix)
+	reset := renderDat(t, `ESC "t" 17 ESC "@" 0xE9 LF`, 0)
+	assert.Equal(t, renderDat(t, `0xE9 LF`, 0).Pix, reset.Pix)
+}
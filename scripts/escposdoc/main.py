@@ -4,7 +4,9 @@
 Generate a CSV file with ESC/POS commands from the Epson website.
 """
 import escpos.escpos as escpos
+import csv
 import logging
+import sys
 
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger(__name__)
@@ -23,7 +25,23 @@ def debug_command():
         logger.info(f"Parsed command from file: {cmd.title} - {cmd.name}: {cmd.format}")
 
 
+def write_index(index: list[escpos.IndexEntry]) -> None:
+    """
+    Write the command index as CSV to stdout, it is the list of commands, that
+    the escpos driver is checked against:
+
+        python3 main.py index > ../../testdata/escpos-3.40-commands.csv
+    """
+    w = csv.writer(sys.stdout, lineterminator="\n")
+    w.writerow(["code", "name"])
+    for entry in index:
+        w.writerow([entry.code, entry.name])
+
+
 if __name__ == "__main__":
+    if sys.argv[1:] == ["index"]:
+        write_index(escpos.command_index())
+        sys.exit(0)
     version = escpos.version()
     logger.info(f"ESC/POS version: {version}")
     index = escpos.command_index()
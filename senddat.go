@@ -4,8 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log/slog"
-	"os"
 	"strconv"
 	"strings"
 	"text/scanner"
@@ -21,71 +19,119 @@ const (
 	sdPrint    = '!'
 
 	// extended senddat commands
-	sdxInclude = '@' // include file
-	sdxImage   = '#' // include image file
+	sdxInclude  = '@' // include file, "@@" parses the file as senddat source
+	sdxImage    = '#' // include image file
+	sdxCodePage = '%' // select the code page for the string literals
 )
 
 // maxStrLen is the maximum string length for the readln
 const maxStrLen = 250
 
 var (
-	// SenddatOutput is the default senddat output stream
-	SenddatOutput = os.Stderr
-
-	gWaitMultiplier time.Duration = 1 // senddat wait multiplier, to turn it off in tests.
+	errTooLong = errors.New("string length exceeded")
+	errNoInput = errors.New("no more input")
 )
 
-var errTooLong = errors.New("string length exceeded")
-
-// senddatCommand is a senddat command executor.
-func senddatCommand(w io.Writer, s *scanner.Scanner, command rune) error {
+// command is a senddat command executor.
+func (p *Parser) command(w io.Writer, s *scanner.Scanner, command rune, st *includeState) error {
+	lg := p.opts.Logger
+	pos := s.Position // position of the command, reading the line invalidates it.
 	switch command {
 	case sdComment:
-		slog.Debug("start of the comment line", "text", s.TokenText(), "line", s.Line, "pos", s.Pos())
+		lg.Debug("start of the comment line", "text", s.TokenText(), "line", s.Line, "pos", s.Pos())
 	case sdDelayMs:
 		val := s.Scan()
 		if val != scanner.Int {
 			return fmt.Errorf("expected integer after '*', got '%s' at line %d, pos %v", s.TokenText(), s.Line, s.Pos())
 		}
 		t := s.TokenText()
-		slog.Debug("delay", "text", t, "line", s.Line, "pos", s.Pos())
+		lg.Debug("delay", "text", t, "line", s.Line, "pos", s.Pos())
 		ms, err := strconv.Atoi(t)
 		if err != nil {
 			return fmt.Errorf("invalid delay value: %s at line %d, pos %v", t, s.Line, s.Pos())
 		}
-		slog.Debug("delay value", "ms", ms, "line", s.Line, "pos", s.Pos())
-		time.Sleep(time.Duration(ms) * time.Millisecond * gWaitMultiplier)
+		lg.Debug("delay value", "ms", ms, "line", s.Line, "pos", s.Pos())
+		// send everything before the delay to the printer.
+		if err := flush(w); err != nil {
+			return fmt.Errorf("error flushing output before delay at line %d: %w", s.Line, err)
+		}
+		p.opts.Sleep(time.Duration(ms) * time.Millisecond)
 	case sdKeyInput:
 		msg, err := readln(s, maxStrLen)
 		if err != nil {
 			return fmt.Errorf("error at position %v: %w", s.Pos(), err)
 		}
-		fmt.Fprintln(SenddatOutput, msg)
-		// fmt.Scanln()
+		fmt.Fprintln(p.opts.Output, msg)
+		if p.opts.Input == nil {
+			break
+		}
+		// the printer should be in the state that the message describes.
+		if err := flush(w); err != nil {
+			return fmt.Errorf("error flushing output before key input at position %v: %w", s.Pos(), err)
+		}
+		answer, err := waitKey(p.opts.Input)
+		if err != nil {
+			return fmt.Errorf("error waiting for key input at position %v: %w", s.Pos(), err)
+		}
+		lg.Debug("key input", "answer", answer, "pos", s.Pos())
 	case sdPrint:
 		msg, err := readln(s, maxStrLen)
 		if err != nil {
 			return fmt.Errorf("error at position %v: %w", s.Pos(), err)
 		}
-		fmt.Fprintln(SenddatOutput, msg)
+		fmt.Fprintln(p.opts.Output, msg)
 	case sdxInclude:
 		filename, err := readln(s, maxStrLen)
 		if err != nil {
 			return fmt.Errorf("error reading include filename at position %v: %w", s.Pos(), err)
 		}
-		slog.Debug("include file", "filename", filename, "line", s.Line, "pos", s.Pos())
-		if n, err := copyfile(w, filename); err != nil {
+		if source, ok := strings.CutPrefix(filename, string(sdxInclude)); ok {
+			lg.Debug("include source", "filename", source, "line", s.Line, "pos", s.Pos())
+			return p.includeSource(w, pos, source, st)
+		}
+		lg.Debug("include file", "filename", filename, "line", s.Line, "pos", s.Pos())
+		// the included commands may select the code page.
+		if n, err := p.copyfile(io.MultiWriter(w, st.cp), st.dir, filename); err != nil {
 			return fmt.Errorf("error including file '%s' at line %d, pos %v: %w", filename, s.Line, s.Pos(), err)
 		} else {
-			slog.Info("included file", "filename", filename, "bytes", n, "line", s.Line, "pos", s.Pos())
+			lg.Info("included file", "filename", filename, "bytes", n, "line", s.Line, "pos", s.Pos())
 		}
 	case sdxImage:
-		slog.Warn("image inclusion is not supported yet", "line", s.Line, "pos", s.Pos())
-		// TODO establish a protocol for image inclusion, i.e. how to handle the image data.
-		_, err := readln(s, maxStrLen)
+		line, err := readln(s, maxStrLen)
 		if err != nil {
 			return fmt.Errorf("error reading image filename at position %v: %w", s.Pos(), err)
 		}
+		filename, opts, err := parseImageDirective(line, p.opts.Image)
+		if err != nil {
+			return fmt.Errorf("error in image directive at line %d, pos %v: %w", s.Line, s.Pos(), err)
+		}
+		lg.Debug("include image", "filename", filename, "opts", opts, "line", s.Line, "pos", s.Pos())
+		if err := p.includeImage(io.MultiWriter(w, st.cp), st.dir, filename, opts); err != nil {
+			return fmt.Errorf("error including image '%s' at line %d, pos %v: %w", filename, s.Line, s.Pos(), err)
+		}
+	case sdxCodePage:
+		name, err := readln(s, maxStrLen)
+		if err != nil {
+			return fmt.Errorf("error reading code page at position %v: %w", s.Pos(), err)
+		}
+		name = strings.TrimSpace(name)
+		lg.Debug("code page", "name", name, "pos", pos)
+		switch normCodePage(name) {
+		case "UTF8", "NONE":
+			st.cp.disable()
+			return nil
+		case normCodePage(AutoCodePage):
+			st.cp.setAuto()
+			return nil
+		}
+		cp, err := p.opts.CodePages.Lookup(name)
+		if err != nil {
+			return fmt.Errorf("error in code page directive at %v: %w", pos, err)
+		}
+		st.cp.set(cp)
+		if _, err := io.MultiWriter(w, st.cp).Write([]byte{byte(bESC), 't', cp.Table}); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unhandled senddat command: '%c'", command)
 	}
@@ -112,10 +158,45 @@ func readln(s *scanner.Scanner, n int) (string, error) {
 	return buf.String(), errTooLong
 }
 
+// waitKey reads the line from r.  It reads byte by byte, so that the rest of
+// the input is available for the following key inputs.
+func waitKey(r io.Reader) (string, error) {
+	var (
+		buf []byte
+		b   [1]byte
+	)
+	for {
+		n, err := r.Read(b[:])
+		if n > 0 {
+			if b[0] == '\n' {
+				return strings.TrimSuffix(string(buf), "\r"), nil
+			}
+			buf = append(buf, b[0])
+		}
+		if err != nil {
+			if err == io.EOF && len(buf) > 0 {
+				return string(buf), nil
+			}
+			if err == io.EOF {
+				err = errNoInput
+			}
+			return string(buf), err
+		}
+	}
+}
+
+// flush flushes w, if it is buffered.
+func flush(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
 // copyfile copies the contents of the file with the given filename to the writer w.
 // It returns the number of bytes copied and an error if any.
-func copyfile(w io.Writer, filename string) (int64, error) {
-	f, err := os.Open(filename)
+func (p *Parser) copyfile(w io.Writer, dir, filename string) (int64, error) {
+	f, _, err := p.open(dir, filename)
 	if err != nil {
 		return 0, fmt.Errorf("error opening file '%s': %w", filename, err)
 	}
@@ -126,3 +207,13 @@ func copyfile(w io.Writer, filename string) (int64, error) {
 	}
 	return n, nil
 }
+
+// includeImage opens the image file, converts it and writes it to w.
+func (p *Parser) includeImage(w io.Writer, dir, filename string, opts ImageOptions) error {
+	f, _, err := p.open(dir, filename)
+	if err != nil {
+		return fmt.Errorf("error opening image '%s': %w", filename, err)
+	}
+	defer f.Close()
+	return includeImage(w, f, opts)
+}
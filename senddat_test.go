@@ -1,8 +1,14 @@
 package senddat
 
 import (
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func init() {
@@ -10,15 +16,31 @@ func init() {
 	if os.Getenv("DEBUG") == "1" {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
-	// set the wait multiplier to 0 to avoid delaying tests.
-	gWaitMultiplier = 0
+}
 
-	// prevent the test strings output to the terminal during tests.
-	null, err := os.Open(os.DevNull)
-	if err != nil {
-		slog.Error("UNABLE TO OPEN NULL DEVICE")
-	} else {
-		SenddatOutput = null
-	}
+// testParser is the parser that does not delay, and does not output the
+// test strings to the terminal.
+var testParser = NewParser(Options{Output: io.Discard, Sleep: func(time.Duration) {}})
 
+func TestParse_keyInput(t *testing.T) {
+	t.Run("waits for each key input", func(t *testing.T) {
+		p := NewParser(Options{Output: io.Discard, Input: strings.NewReader("\r\nyes\n")})
+		var w writeRecorder
+		if err := p.Parse(&w, strings.NewReader("\"AB\"\n.Press Enter\n\"CD\"\n.Again\n\"EF\"")); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, [][]byte{[]byte("AB"), []byte("CD"), []byte("EF")}, w.writes)
+	})
+	t.Run("no more answers", func(t *testing.T) {
+		p := NewParser(Options{Output: io.Discard, Input: strings.NewReader("\n")})
+		err := p.Parse(io.Discard, strings.NewReader(".One\n.Two\n"))
+		assert.ErrorIs(t, err, errNoInput)
+	})
+	t.Run("nil input does not wait", func(t *testing.T) {
+		var w writeRecorder
+		if err := testParser.Parse(&w, strings.NewReader("\"AB\"\n.Press Enter\n\"CD\"")); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, [][]byte{[]byte("ABCD")}, w.writes)
+	})
 }
@@ -0,0 +1,219 @@
+package senddat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Real-time status types for DLE EOT n.
+const (
+	StatusPrinter     = 1 // printer status
+	StatusOffline     = 2 // offline cause status
+	StatusError       = 3 // error cause status
+	StatusPaperSensor = 4 // roll paper sensor status
+)
+
+// DefaultStatusTimeout is the default time to wait for the status response.
+const DefaultStatusTimeout = 2 * time.Second
+
+// ASB (GS a n) status change flags.
+const (
+	ASBDrawer      = 0x01 // drawer kick-out connector pin 3 status
+	ASBOnline      = 0x02 // online/offline status
+	ASBError       = 0x04 // error status
+	ASBPaperSensor = 0x08 // roll paper sensor status
+	ASBAll         = ASBDrawer | ASBOnline | ASBError | ASBPaperSensor
+)
+
+// ErrInvalidStatus is returned if the printer response does not look like a
+// status.
+var ErrInvalidStatus = errors.New("invalid status response")
+
+// Status is the printer status.
+type Status struct {
+	// Offline is true if the printer is offline.
+	Offline bool `json:"offline"`
+	// DrawerKick is the state of the drawer kick-out connector pin 3, true
+	// if it is high.  Depending on the drawer, it means that the drawer is
+	// open or closed.
+	DrawerKick bool `json:"drawer_kick"`
+	// CoverOpen is true if the cover is open.
+	CoverOpen bool `json:"cover_open"`
+	// PaperFeed is true if the paper is being fed by the paper feed button.
+	PaperFeed bool `json:"paper_feed"`
+	// WaitingRecovery is true if the printer waits for the online recovery.
+	WaitingRecovery bool `json:"waiting_recovery"`
+	// PaperEndStop is true if printing is stopped due to the paper end.
+	PaperEndStop bool `json:"paper_end_stop"`
+	// Error is true if an error occurred.
+	Error bool `json:"error"`
+	// MechanicalError is true if a recoverable mechanical error occurred.
+	MechanicalError bool `json:"mechanical_error"`
+	// AutocutterError is true if an autocutter error occurred.
+	AutocutterError bool `json:"autocutter_error"`
+	// UnrecoverableError is true if an unrecoverable error occurred.
+	UnrecoverableError bool `json:"unrecoverable_error"`
+	// AutoRecoverableError is true if an automatically recoverable error
+	// occurred, i.e. the head overheated.
+	AutoRecoverableError bool `json:"auto_recoverable_error"`
+	// PaperNearEnd is true if the roll paper near-end sensor detects the
+	// paper near end.
+	PaperNearEnd bool `json:"paper_near_end"`
+	// PaperEnd is true if the roll paper end sensor detects the paper end.
+	PaperEnd bool `json:"paper_end"`
+}
+
+func (s Status) String() string {
+	flags := []struct {
+		set  bool
+		name string
+	}{
+		{s.Offline, "offline"},
+		{s.DrawerKick, "drawer kick high"},
+		{s.CoverOpen, "cover open"},
+		{s.PaperFeed, "paper feed"},
+		{s.WaitingRecovery, "waiting recovery"},
+		{s.PaperEndStop, "stopped at paper end"},
+		{s.Error, "error"},
+		{s.MechanicalError, "mechanical error"},
+		{s.AutocutterError, "autocutter error"},
+		{s.UnrecoverableError, "unrecoverable error"},
+		{s.AutoRecoverableError, "auto-recoverable error"},
+		{s.PaperNearEnd, "paper near end"},
+		{s.PaperEnd, "paper end"},
+	}
+	var set []string
+	for _, f := range flags {
+		if f.set {
+			set = append(set, f.name)
+		}
+	}
+	if len(set) == 0 {
+		return "online, ready"
+	}
+	return strings.Join(set, ", ")
+}
+
+func bit(b byte, n uint) bool {
+	return b&(1<<n) != 0
+}
+
+// isStatusByte checks the fixed bits of the DLE EOT response: bits 1 and 4
+// are set, bits 0 and 7 are clear.
+func isStatusByte(b byte) bool {
+	return b&0x93 == 0x12
+}
+
+// decodeStatus sets the status fields from the DLE EOT n response byte.
+func (s *Status) decodeStatus(n int, b byte) error {
+	if !isStatusByte(b) {
+		return fmt.Errorf("%w: DLE EOT %d: %02X", ErrInvalidStatus, n, b)
+	}
+	switch n {
+	case StatusPrinter:
+		s.DrawerKick = bit(b, 2)
+		s.Offline = bit(b, 3)
+		s.WaitingRecovery = bit(b, 5)
+		s.PaperFeed = bit(b, 6)
+	case StatusOffline:
+		s.CoverOpen = bit(b, 2)
+		s.PaperFeed = s.PaperFeed || bit(b, 3)
+		s.PaperEndStop = bit(b, 5)
+		s.Error = bit(b, 6)
+	case StatusError:
+		s.MechanicalError = bit(b, 2)
+		s.AutocutterError = bit(b, 3)
+		s.UnrecoverableError = bit(b, 5)
+		s.AutoRecoverableError = bit(b, 6)
+	case StatusPaperSensor:
+		s.PaperNearEnd = bit(b, 2) || bit(b, 3)
+		s.PaperEnd = bit(b, 5) || bit(b, 6)
+	default:
+		return fmt.Errorf("unknown status type: %d", n)
+	}
+	return nil
+}
+
+// DecodeStatus decodes the responses to DLE EOT 1, 2, 3 and 4 real-time
+// status requests, in this order.  Missing responses are treated as no
+// flags set.
+func DecodeStatus(responses ...byte) (Status, error) {
+	var s Status
+	for i, b := range responses {
+		if err := s.decodeStatus(i+1, b); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// DecodeASB decodes the 4-byte Automatic Status Back message.
+func DecodeASB(asb [4]byte) (Status, error) {
+	if asb[0]&0x93 != 0x10 || asb[1]&0x90 != 0 || asb[2]&0x90 != 0 || asb[3]&0x90 != 0 {
+		return Status{}, fmt.Errorf("%w: ASB % X", ErrInvalidStatus, asb)
+	}
+	return Status{
+		DrawerKick:           bit(asb[0], 2),
+		Offline:              bit(asb[0], 3),
+		CoverOpen:            bit(asb[0], 5),
+		PaperFeed:            bit(asb[0], 6),
+		MechanicalError:      bit(asb[1], 2),
+		AutocutterError:      bit(asb[1], 3),
+		UnrecoverableError:   bit(asb[1], 5),
+		AutoRecoverableError: bit(asb[1], 6),
+		Error:                asb[1]&0x6C != 0,
+		PaperNearEnd:         bit(asb[2], 0) || bit(asb[2], 1),
+		PaperEnd:             bit(asb[2], 2) || bit(asb[2], 3),
+	}, nil
+}
+
+// readDeadline reads exactly len(p) bytes, waiting at most the timeout.
+func readDeadline(t DuplexTransport, p []byte, timeout time.Duration) error {
+	if timeout > 0 {
+		if err := t.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		defer t.SetReadDeadline(time.Time{})
+	}
+	_, err := io.ReadFull(t, p)
+	return err
+}
+
+// QueryStatus requests the real-time status with DLE EOT 1..4 and returns
+// the decoded status.  Each response is awaited for the timeout.
+func QueryStatus(t DuplexTransport, timeout time.Duration) (Status, error) {
+	var s Status
+	for n := StatusPrinter; n <= StatusPaperSensor; n++ {
+		if _, err := t.Write([]byte{byte(bDLE), byte(bEOT), byte(n)}); err != nil {
+			return s, fmt.Errorf("failed to send DLE EOT %d: %w", n, err)
+		}
+		var resp [1]byte
+		if err := readDeadline(t, resp[:], timeout); err != nil {
+			return s, fmt.Errorf("no response to DLE EOT %d: %w", n, err)
+		}
+		if err := s.decodeStatus(n, resp[0]); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// EnableASB enables the Automatic Status Back with GS a n for the status
+// changes in flags, i.e. ASBAll.  Zero flags disable ASB.
+func EnableASB(t Transport, flags byte) error {
+	_, err := t.Write([]byte{byte(bGS), 'a', flags})
+	return err
+}
+
+// ReadASB waits for the Automatic Status Back message for the timeout, and
+// returns the decoded status.  Zero timeout waits indefinitely.
+func ReadASB(t DuplexTransport, timeout time.Duration) (Status, error) {
+	var asb [4]byte
+	if err := readDeadline(t, asb[:], timeout); err != nil {
+		return Status{}, fmt.Errorf("failed to read ASB: %w", err)
+	}
+	return DecodeASB(asb)
+}
@@ -0,0 +1,189 @@
+package senddat
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ DuplexTransport = (*TCPTransport)(nil)
+	_ DuplexTransport = (*SerialTransport)(nil)
+)
+
+// mockPrinter is a TCP listener that answers DLE EOT n with status[n-1], and
+// GS a n with the asb messages, if n is not zero.
+type mockPrinter struct {
+	l      net.Listener
+	status [4]byte
+	asb    [][4]byte
+}
+
+func newMockPrinter(t *testing.T, status [4]byte, asb ...[4]byte) *mockPrinter {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := &mockPrinter{l: l, status: status, asb: asb}
+	go mp.serve()
+	t.Cleanup(func() { l.Close() })
+	return mp
+}
+
+func (mp *mockPrinter) serve() {
+	conn, err := mp.l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch ControlCode(b) {
+		case bDLE:
+			cmd := make([]byte, 2)
+			if _, err := io.ReadFull(r, cmd); err != nil || cmd[0] != byte(bEOT) || cmd[1] < 1 || cmd[1] > 4 {
+				return
+			}
+			conn.Write([]byte{mp.status[cmd[1]-1]})
+		case bGS:
+			cmd := make([]byte, 2)
+			if _, err := io.ReadFull(r, cmd); err != nil || cmd[0] != 'a' {
+				return
+			}
+			if cmd[1] == 0 {
+				continue
+			}
+			for _, asb := range mp.asb {
+				conn.Write(asb[:])
+			}
+		}
+	}
+}
+
+func (mp *mockPrinter) dial(t *testing.T) *TCPTransport {
+	t.Helper()
+	tr, err := DialTCP(mp.l.Addr().String(), TransportOptions{ConnectTimeout: time.Second, WriteTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func TestQueryStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  [4]byte
+		want    Status
+		wantErr error
+	}{
+		{
+			name:   "ready",
+			status: [4]byte{0x12, 0x12, 0x12, 0x12},
+			want:   Status{},
+		},
+		{
+			name:   "cover open",
+			status: [4]byte{0x1e, 0x16, 0x12, 0x12},
+			want:   Status{Offline: true, DrawerKick: true, CoverOpen: true},
+		},
+		{
+			name:   "paper end",
+			status: [4]byte{0x3a, 0x72, 0x12, 0x7e},
+			want:   Status{Offline: true, WaitingRecovery: true, PaperEndStop: true, Error: true, PaperNearEnd: true, PaperEnd: true},
+		},
+		{
+			name:   "autocutter error",
+			status: [4]byte{0x1a, 0x52, 0x1a, 0x12},
+			want:   Status{Offline: true, Error: true, AutocutterError: true},
+		},
+		{
+			name:    "not a status",
+			status:  [4]byte{0xff, 0x12, 0x12, 0x12},
+			wantErr: ErrInvalidStatus,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newMockPrinter(t, tt.status).dial(t)
+			got, err := QueryStatus(tr, time.Second)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("QueryStatus() error = %v", err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestQueryStatus_timeout(t *testing.T) {
+	fp := newFakePrinter(t, true) // receives, but never answers.
+	tr, err := DialTCP(fp.l.Addr().String(), TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	_, err = QueryStatus(tr, 50*time.Millisecond)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "got %v", err)
+}
+
+func TestReadASB(t *testing.T) {
+	mp := newMockPrinter(t, [4]byte{}, [4]byte{0x10, 0, 0, 0}, [4]byte{0x38, 0, 0x0f, 0})
+	tr := mp.dial(t)
+	if err := EnableASB(tr, ASBAll); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadASB(tr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Status{}, got)
+	got, err = ReadASB(tr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Status{Offline: true, CoverOpen: true, PaperNearEnd: true, PaperEnd: true}, got)
+}
+
+func TestDecodeASB(t *testing.T) {
+	tests := []struct {
+		name    string
+		asb     [4]byte
+		want    Status
+		wantErr bool
+	}{
+		{"ready", [4]byte{0x10, 0x00, 0x00, 0x0f}, Status{}, false},
+		{"drawer and feed", [4]byte{0x54, 0x00, 0x00, 0x00}, Status{DrawerKick: true, PaperFeed: true}, false},
+		{"unrecoverable", [4]byte{0x18, 0x20, 0x00, 0x00}, Status{Offline: true, Error: true, UnrecoverableError: true}, false},
+		{"invalid header", [4]byte{0x00, 0x00, 0x00, 0x00}, Status{}, true},
+		{"invalid byte", [4]byte{0x10, 0x80, 0x00, 0x00}, Status{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeASB(tt.asb)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeASB() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStatus_String(t *testing.T) {
+	assert.Equal(t, "online, ready", Status{}.String())
+	assert.Equal(t, "offline, cover open", Status{Offline: true, CoverOpen: true}.String())
+}
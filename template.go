@@ -2,12 +2,22 @@ package senddat
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"io/fs"
 	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"text/template"
+	"text/template/parse"
 	"unicode/utf8"
 )
 
+// maxTemplateSize is the maximum size of the template source.
+const maxTemplateSize = 1 << 20
+
 var tmplfuncs = template.FuncMap{
 	"count":      count,
 	"count_step": countStep,
@@ -15,22 +25,182 @@ var tmplfuncs = template.FuncMap{
 	"strcat":     strcat,
 }
 
-var basetmpl = template.New("").Funcs(tmplfuncs)
-
+// ParseFromTemplate executes the template from r with the default options,
+// and parses the result.
 func ParseFromTemplate(w io.Writer, r io.Reader) error {
-	data, err := io.ReadAll(io.LimitReader(r, 1048576))
+	return NewParser(Options{}).ParseFromTemplate(w, r)
+}
+
+// ParseFromTemplateWithData executes the template from r with the data with
+// the default options, and parses the result.
+func ParseFromTemplateWithData(w io.Writer, r io.Reader, data any) error {
+	return NewParser(Options{}).ParseFromTemplateWithData(w, r, data)
+}
+
+// ParseFromTemplate executes the template from r, and parses the result.  The
+// includes are resolved as in [Parser.Parse].
+func (p *Parser) ParseFromTemplate(w io.Writer, r io.Reader) error {
+	return p.ParseFromTemplateWithData(w, r, nil)
+}
+
+// ParseFromTemplateWithData executes the template from r with the data, and
+// parses the result.  The includes are resolved as in [Parser.Parse].
+func (p *Parser) ParseFromTemplateWithData(w io.Writer, r io.Reader, data any) error {
+	ts := p.NewTemplateSet()
+	if err := ts.Add("", r); err != nil {
+		return err
+	}
+	return ts.Execute(w, "", data)
+}
+
+// TemplateSet is the set of templates, loaded from one or more files, that
+// can refer to each other with {{ template "name" . }}.
+type TemplateSet struct {
+	p     *Parser
+	tmpl  *template.Template
+	files map[string]string // template name -> file name, to resolve includes
+}
+
+// NewTemplateSet returns the empty template set, that is executed with the
+// parser options.
+func (p *Parser) NewTemplateSet() *TemplateSet {
+	return &TemplateSet{
+		p:     p,
+		tmpl:  template.New("").Funcs(p.templateFuncs(p.newReceipt())),
+		files: make(map[string]string),
+	}
+}
+
+// Add parses the template source from r, up to 1 MiB, as the template with
+// the name.  Templates defined with "define" or "block" replace the earlier
+// definitions with the same name, so that the templates added later override
+// the parts of the layout.
+func (ts *TemplateSet) Add(name string, r io.Reader) error {
+	src, err := io.ReadAll(io.LimitReader(r, maxTemplateSize))
 	if err != nil {
 		return err
 	}
-	tmpl, err := basetmpl.Parse(string(data))
+	before := make(map[string]*parse.Tree)
+	for _, t := range ts.tmpl.Templates() {
+		before[t.Name()] = t.Tree
+	}
+	t := ts.tmpl
+	if name != t.Name() {
+		t = t.New(name)
+	}
+	if _, err := t.Parse(string(src)); err != nil {
+		return err
+	}
+	if nr, ok := r.(interface{ Name() string }); ok {
+		// templates defined in this file resolve includes relative to it.
+		for _, t := range ts.tmpl.Templates() {
+			if t.Tree != nil && t.Tree != before[t.Name()] {
+				ts.files[t.Name()] = nr.Name()
+			}
+		}
+	}
+	return nil
+}
+
+// AddFiles adds the template files matching the glob patterns, in order.  If
+// the pattern is a directory, all .tmpl files in it are added.  The
+// templates are named by the base name of the file, i.e. "header.tmpl".
+func (ts *TemplateSet) AddFiles(patterns ...string) error {
+	for _, pattern := range patterns {
+		if ts.isDir(pattern) {
+			pattern = filepath.Join(pattern, "*.tmpl")
+		}
+		files, err := ts.glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid template pattern %q: %w", pattern, err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no templates match %q", pattern)
+		}
+		for _, file := range files {
+			if err := ts.addFile(file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ts *TemplateSet) addFile(file string) error {
+	f, err := ts.p.openFile(file)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
+	return ts.Add(filepath.Base(file), namedReader{Reader: f, name: file})
+}
+
+func (ts *TemplateSet) isDir(name string) bool {
+	var (
+		fi  fs.FileInfo
+		err error
+	)
+	if ts.p.opts.FS == nil {
+		fi, err = os.Stat(name)
+	} else {
+		fi, err = fs.Stat(ts.p.opts.FS, filepath.ToSlash(filepath.Clean(name)))
+	}
+	return err == nil && fi.IsDir()
+}
+
+func (ts *TemplateSet) glob(pattern string) ([]string, error) {
+	if ts.p.opts.FS == nil {
+		return filepath.Glob(pattern)
+	}
+	return fs.Glob(ts.p.opts.FS, filepath.ToSlash(filepath.Clean(pattern)))
+}
+
+// namedReader is the reader with the file name, the files opened from fs.FS
+// do not have it.
+type namedReader struct {
+	io.Reader
+	name string
+}
+
+func (r namedReader) Name() string { return r.name }
+
+// Templates returns the names of the templates in the set.
+func (ts *TemplateSet) Templates() []string {
+	var names []string
+	for _, t := range ts.tmpl.Templates() {
+		if t.Tree != nil {
+			names = append(names, t.Name())
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Execute executes the template with the name and the data, and parses the
+// result.  Includes are resolved relative to the file of the template.  It is
+// safe to call Execute concurrently.
+func (ts *TemplateSet) Execute(w io.Writer, name string, data any) error {
+	if ts.tmpl.Lookup(name) == nil {
+		return fmt.Errorf("template %q is not defined, have: %s", name, strings.Join(ts.Templates(), ", "))
+	}
+	// the functions have the state of the execution.
+	tmpl, err := ts.tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	rc := ts.p.newReceipt()
 	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, nil); err != nil {
+	if err := tmpl.Funcs(ts.p.templateFuncs(rc)).ExecuteTemplate(&buf, name, data); err != nil {
 		return err
 	}
-	return Parse(w, &buf)
+	st := &includeState{}
+	if file, ok := ts.files[name]; ok {
+		st = newFileState(file)
+	}
+	if rc.layout {
+		w = newSizeChecker(w, rc, ts.p.commandSpecs())
+	}
+	return ts.p.parseRoot(w, &buf, st)
 }
 
 // count returns an iterator that will count from [start..end]
@@ -0,0 +1,116 @@
+package senddat
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParser_ParseFromTemplateWithData(t *testing.T) {
+	const src = `"{{ .store.name }}" LF
+{{ range .items }}"{{ .name }}: {{ .qty }}" LF
+{{ end }}`
+	data := map[string]any{
+		"store": map[string]any{"name": "Corner Shop"},
+		"items": []any{
+			map[string]any{"name": "Tea", "qty": 2},
+			map[string]any{"name": "Milk", "qty": 1},
+		},
+	}
+	var buf strings.Builder
+	if err := testParser.ParseFromTemplateWithData(&buf, strings.NewReader(src), data); err != nil {
+		t.Fatalf("ParseFromTemplateWithData() error = %v", err)
+	}
+	assert.Equal(t, "Corner Shop\nTea: 2\nMilk: 1\n", buf.String())
+}
+
+func TestParser_ParseFromTemplate_env(t *testing.T) {
+	t.Setenv("SENDDAT_TEST_STORE", "Kiosk")
+	t.Setenv("SECRET_TOKEN", "42")
+	tests := []struct {
+		name    string
+		env     []string
+		src     string
+		want    string
+		wantErr bool
+	}{
+		{"name", []string{"SENDDAT_TEST_STORE"}, `"{{ env "SENDDAT_TEST_STORE" }}"`, "Kiosk", false},
+		{"prefix", []string{"SENDDAT_*"}, `"{{ env "SENDDAT_TEST_STORE" }}"`, "Kiosk", false},
+		{"all", []string{"*"}, `"{{ env "SECRET_TOKEN" }}"`, "42", false},
+		{"not allowed", []string{"SENDDAT_*"}, `"{{ env "SECRET_TOKEN" }}"`, "", true},
+		{"disabled", nil, `"{{ env "SENDDAT_TEST_STORE" }}"`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(Options{Output: io.Discard, Env: tt.env})
+			var buf strings.Builder
+			err := p.ParseFromTemplate(&buf, strings.NewReader(tt.src))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFromTemplate() error = %v", err)
+			}
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestTemplateSet(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base/layout.tmpl":     {Data: []byte(`{{ define "receipt" }}{{ block "header" . }}"HEADER" LF {{ end }}"{{ .item }}" LF {{ template "footer.tmpl" . }}{{ end }}`)},
+		"base/footer.tmpl":     {Data: []byte(`"Thank you" LF @logo.prn` + "\n")},
+		"base/logo.prn":        {Data: []byte("LOGO")},
+		"store/42/header.tmpl": {Data: []byte(`{{ define "header" }}"STORE 42" LF {{ end }}`)},
+	}
+	p := NewParser(Options{Output: io.Discard, FS: fsys})
+	data := map[string]any{"item": "Tea"}
+
+	t.Run("base layout", func(t *testing.T) {
+		ts := p.NewTemplateSet()
+		if err := ts.AddFiles("base"); err != nil {
+			t.Fatal(err)
+		}
+		var buf strings.Builder
+		if err := ts.Execute(&buf, "receipt", data); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		// includes are resolved relative to the file of the entry template.
+		assert.Equal(t, "HEADER\nTea\nThank you\nLOGO", buf.String())
+		assert.Equal(t, []string{"footer.tmpl", "header", "layout.tmpl", "receipt"}, ts.Templates())
+	})
+	t.Run("store override", func(t *testing.T) {
+		ts := p.NewTemplateSet()
+		if err := ts.AddFiles("base/*.tmpl", "store/42"); err != nil {
+			t.Fatal(err)
+		}
+		var buf strings.Builder
+		if err := ts.Execute(&buf, "receipt", data); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		assert.Equal(t, "STORE 42\nTea\nThank you\nLOGO", buf.String())
+	})
+	t.Run("entry from reader", func(t *testing.T) {
+		ts := p.NewTemplateSet()
+		if err := ts.AddFiles("base"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.Add("main", strings.NewReader(`"#1" LF {{ template "header" }}`)); err != nil {
+			t.Fatal(err)
+		}
+		var buf strings.Builder
+		if err := ts.Execute(&buf, "main", nil); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		assert.Equal(t, "#1\nHEADER\n", buf.String())
+	})
+	t.Run("errors", func(t *testing.T) {
+		ts := p.NewTemplateSet()
+		assert.Error(t, ts.AddFiles("none/*.tmpl"))
+		assert.Error(t, ts.Execute(io.Discard, "receipt", nil))
+	})
+}
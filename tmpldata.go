@@ -0,0 +1,68 @@
+package senddat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var errInvalidVar = errors.New("invalid template variable")
+
+// LoadTemplateData loads the template data from the JSON or YAML file, the
+// format is chosen by the file extension: .json, .yaml or .yml.
+func LoadTemplateData(filename string) (any, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template data: %w", err)
+	}
+	var v any
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &v)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &v)
+	default:
+		return nil, fmt.Errorf("unsupported template data format: %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decoding template data '%s': %w", filename, err)
+	}
+	return v, nil
+}
+
+// SetTemplateVar sets the variable in the template data from the "key=value"
+// assignment.  Dotted keys set the nested values, i.e. "store.name=Corner
+// Shop" is available in the template as {{ .store.name }}.  The data must be
+// nil or a map, it returns the updated data.
+func SetTemplateVar(data any, assignment string) (any, error) {
+	key, value, ok := strings.Cut(assignment, "=")
+	if !ok || key == "" {
+		return data, fmt.Errorf("%w: %q, expected key=value", errInvalidVar, assignment)
+	}
+	if data == nil {
+		data = map[string]any{}
+	}
+	m, ok := data.(map[string]any)
+	if !ok {
+		return data, fmt.Errorf("%w: %s: template data is not an object", errInvalidVar, key)
+	}
+	path := strings.Split(key, ".")
+	for _, name := range path[:len(path)-1] {
+		next, ok := m[name].(map[string]any)
+		if !ok {
+			if _, exists := m[name]; exists {
+				return data, fmt.Errorf("%w: %s: %s is not an object", errInvalidVar, key, name)
+			}
+			next = map[string]any{}
+			m[name] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+	return data, nil
+}
@@ -0,0 +1,104 @@
+package senddat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTemplateData(t *testing.T) {
+	dir := t.TempDir()
+	want := map[string]any{
+		"store": map[string]any{"name": "Corner Shop"},
+		"items": []any{map[string]any{"name": "Tea", "price": 1.5}},
+	}
+	files := map[string]string{
+		"order.json": `{"store": {"name": "Corner Shop"}, "items": [{"name": "Tea", "price": 1.5}]}`,
+		"order.yaml": "store:\n  name: Corner Shop\nitems:\n  - name: Tea\n    price: 1.5\n",
+		"order.txt":  "",
+		"bad.json":   "{",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"order.json", "order.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			got, err := LoadTemplateData(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+	for _, name := range []string{"order.txt", "bad.json", "missing.json"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadTemplateData(filepath.Join(dir, name))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSetTemplateVar(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    any
+		vars    []string
+		want    any
+		wantErr bool
+	}{
+		{
+			name: "nil data",
+			vars: []string{"cashier=Ann", "total=12.50"},
+			want: map[string]any{"cashier": "Ann", "total": "12.50"},
+		},
+		{
+			name: "nested override",
+			data: map[string]any{"store": map[string]any{"name": "Shop", "city": "Paris"}},
+			vars: []string{"store.name=Corner Shop", "store.till.id=3"},
+			want: map[string]any{"store": map[string]any{"name": "Corner Shop", "city": "Paris", "till": map[string]any{"id": "3"}}},
+		},
+		{
+			name: "value with equals sign",
+			vars: []string{"expr=a=b"},
+			want: map[string]any{"expr": "a=b"},
+		},
+		{
+			name:    "no assignment",
+			vars:    []string{"cashier"},
+			wantErr: true,
+		},
+		{
+			name:    "not an object",
+			data:    []any{1, 2},
+			vars:    []string{"a=b"},
+			wantErr: true,
+		},
+		{
+			name:    "not a nested object",
+			data:    map[string]any{"store": "Shop"},
+			vars:    []string{"store.name=x"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data
+			var err error
+			for _, v := range tt.vars {
+				if data, err = SetTemplateVar(data, v); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetTemplateVar() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.want, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package senddat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/width"
+)
+
+// receipt is the state of a single template execution, the functions that
+// lay out the text take the character size into account.
+type receipt struct {
+	locale language.Tag
+	// scaleX is the current character width multiplier, set by "size".
+	scaleX int
+	// sizes are the GS ! arguments output by "size", in order.
+	sizes []byte
+	// layout is set, if the layout functions were called.
+	layout bool
+}
+
+func (p *Parser) newReceipt() *receipt {
+	return &receipt{locale: p.opts.Locale, scaleX: 1}
+}
+
+// templateFuncs returns the template functions for a single template
+// execution with the state rc.
+func (p *Parser) templateFuncs(rc *receipt) template.FuncMap {
+	funcs := template.FuncMap{
+		"padleft":  rc.padLeft,
+		"padright": rc.padRight,
+		"center":   rc.center,
+		"columns":  rc.columns,
+		"wrap":     rc.wrap,
+		"size":     rc.size,
+		"number":   rc.number,
+		"money":    rc.money,
+		"date":     date,
+		"now":      time.Now,
+		"repeat":   repeat,
+		"upper":    strings.ToUpper,
+		"lower":    strings.ToLower,
+		"hex":      hexLiteral,
+		"byte":     byteLiteral,
+		"env":      p.getenv,
+	}
+	for name, fn := range tmplfuncs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// cols returns the number of columns of the current character size that fit
+// into n columns of the normal size.
+func (rc *receipt) cols(n int) int {
+	rc.layout = true
+	return n / rc.scaleX
+}
+
+// size returns the GS ! command that sets the character size to w×h, 1..8,
+// and makes the layout functions account for the width.
+func (rc *receipt) size(w, h int) (string, error) {
+	if w < 1 || w > 8 || h < 1 || h > 8 {
+		return "", fmt.Errorf("invalid character size %dx%d, must be 1..8", w, h)
+	}
+	rc.scaleX = w
+	n := byte((w-1)<<4 | (h - 1))
+	rc.sizes = append(rc.sizes, n)
+	return fmt.Sprintf(`GS "!" 0x%02X`, n), nil
+}
+
+// sizeChecker follows the character size commands in the output of the
+// template, that uses the layout functions.  The layout functions only know
+// the width set with "size", so GS ! that is not output by "size", and ESC !
+// that changes the width, are rejected.
+type sizeChecker struct {
+	w     io.Writer
+	rc    *receipt
+	cmds  *commandTracker
+	next  int // next size in rc.sizes
+	width int // current character width
+}
+
+func newSizeChecker(w io.Writer, rc *receipt, specs []CommandSpec) *sizeChecker {
+	return &sizeChecker{w: w, rc: rc, cmds: newCommandTracker(specs), width: 1}
+}
+
+func (c *sizeChecker) Write(p []byte) (int, error) {
+	for _, b := range p {
+		cs, args := c.cmds.feed(b)
+		if cs == nil || len(args) == 0 {
+			continue
+		}
+		switch string(cs.Prefix) {
+		case "\x1d!":
+			if c.next >= len(c.rc.sizes) || c.rc.sizes[c.next] != args[0] {
+				return 0, fmt.Errorf("GS \"!\" 0x%02X is not output by size, the layout functions do not follow it, use size", args[0])
+			}
+			c.next++
+			c.width = int(args[0]>>4) + 1
+		case "\x1b!":
+			if width := 1 + int(args[0]>>5&1); width != c.width {
+				return 0, fmt.Errorf("ESC \"!\" 0x%02X changes the character width, the layout functions do not follow it, use size", args[0])
+			}
+		}
+	}
+	return c.w.Write(p)
+}
+
+// textWidth returns the number of columns that the string occupies, wide
+// (i.e. CJK) characters take two columns.
+func textWidth(s string) int {
+	var n int
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case isWide(r):
+			n += 2
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+func isWide(r rune) bool {
+	k := width.LookupRune(r).Kind()
+	return k == width.EastAsianWide || k == width.EastAsianFullwidth
+}
+
+// truncate cuts s to n columns.
+func truncate(s string, n int) string {
+	var w int
+	for i, r := range s {
+		rw := textWidth(string(r))
+		if w+rw > n {
+			return s[:i]
+		}
+		w += rw
+	}
+	return s
+}
+
+// padLeft right-aligns the value in n columns.  Longer values are not
+// truncated.
+func (rc *receipt) padLeft(n int, v any) string {
+	s := toString(v)
+	return strings.Repeat(" ", max(0, rc.cols(n)-textWidth(s))) + s
+}
+
+// padRight left-aligns the value in n columns.
+func (rc *receipt) padRight(n int, v any) string {
+	s := toString(v)
+	return s + strings.Repeat(" ", max(0, rc.cols(n)-textWidth(s)))
+}
+
+// center centers the value in n columns.
+func (rc *receipt) center(n int, v any) string {
+	s := toString(v)
+	pad := max(0, rc.cols(n)-textWidth(s))
+	return strings.Repeat(" ", pad/2) + s + strings.Repeat(" ", pad-pad/2)
+}
+
+// columns lays out the left and right values in n columns, the left value is
+// truncated if both do not fit.
+func (rc *receipt) columns(n int, left, right any) string {
+	l, r := toString(left), toString(right)
+	n = rc.cols(n)
+	l = truncate(l, max(0, n-textWidth(r)-1))
+	return l + strings.Repeat(" ", max(1, n-textWidth(l)-textWidth(r))) + r
+}
+
+// wrap splits the text into lines of at most n columns, breaking on spaces.
+// Words longer than the line are split.
+func (rc *receipt) wrap(n int, v any) []string {
+	n = max(1, rc.cols(n))
+	var (
+		lines []string
+		line  string
+	)
+	for _, word := range strings.Fields(toString(v)) {
+		for textWidth(word) > n {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			head := truncate(word, n)
+			if head == "" { // wide rune in a single column.
+				head = string([]rune(word)[:1])
+			}
+			lines = append(lines, head)
+			word = word[len(head):]
+		}
+		switch {
+		case word == "":
+		case line == "":
+			line = word
+		case textWidth(line)+1+textWidth(word) <= n:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// number formats the value with the number of decimals, rounding half away
+// from zero.  The value is rounded as the decimal it is written as, so that
+// 2.675 becomes 2.68.  If the parser has the locale, the value is formatted
+// with its separators.
+func (rc *receipt) number(decimals int, v any) (string, error) {
+	if decimals < 0 {
+		return "", fmt.Errorf("number: invalid decimals: %d", decimals)
+	}
+	r, err := toRat(v)
+	if err != nil {
+		return "", err
+	}
+	s := roundRat(r, decimals)
+	if rc.locale == language.Und {
+		return s, nil
+	}
+	return localizeNumber(message.NewPrinter(rc.locale), s), nil
+}
+
+// localizeNumber formats the decimal number s, i.e. "-1234.50", with the
+// digits, separators and minus sign of the locale.  The printer formats
+// through float64, that loses the digits above 2^53, so they are taken from
+// the numbers formatted by the printer and applied to s.
+func localizeNumber(pr *message.Printer, s string) string {
+	// the probe gives the digits and the grouping, i.e.
+	// "12,34,56,78,90,12,34,56,789" for Hindi.
+	const probe = "1234567890123456789"
+	var (
+		digits = make(map[byte]string, 10)
+		groups []int // group sizes, from the right
+		sep    string
+		size   int
+		n      = len(probe)
+	)
+	grouped := []rune(pr.Sprintf("%d", int64(1234567890123456789)))
+	for i := len(grouped) - 1; i >= 0 && n > 0; i-- {
+		if !unicode.IsDigit(grouped[i]) {
+			sep = string(grouped[i])
+			groups = append(groups, size)
+			size = 0
+			continue
+		}
+		n--
+		digits[probe[n]] = string(grouped[i])
+		size++
+	}
+	localize := func(ds string) string {
+		var sb strings.Builder
+		for i := range len(ds) {
+			sb.WriteString(digits[ds[i]])
+		}
+		return sb.String()
+	}
+
+	var sb strings.Builder
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		sb.WriteString(strings.TrimSuffix(pr.Sprintf("%d", -1), digits['1']))
+		s = rest
+	}
+	intPart, frac, _ := strings.Cut(s, ".")
+	var parts []string
+	for i := 0; intPart != ""; i++ {
+		size := len(intPart)
+		if len(groups) > 0 {
+			size = min(size, groups[min(i, len(groups)-1)])
+		}
+		parts = append(parts, localize(intPart[len(intPart)-size:]))
+		intPart = intPart[:len(intPart)-size]
+	}
+	slices.Reverse(parts)
+	sb.WriteString(strings.Join(parts, sep))
+	if frac != "" {
+		point := []rune(pr.Sprintf("%.1f", 1.5))
+		sb.WriteString(string(point[1 : len(point)-1]))
+		sb.WriteString(localize(frac))
+	}
+	return sb.String()
+}
+
+// roundRat returns the decimal representation of r, rounded half away from
+// zero to the decimals.
+func roundRat(r *big.Rat, decimals int) string {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	x := new(big.Rat).Abs(r)
+	x.Mul(x, new(big.Rat).SetInt(scale))
+	x.Add(x, big.NewRat(1, 2))
+	q := new(big.Int).Quo(x.Num(), x.Denom())
+
+	digits := q.String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	var sign string
+	if r.Sign() < 0 && q.Sign() != 0 {
+		sign = "-"
+	}
+	if decimals == 0 {
+		return sign + digits
+	}
+	i := len(digits) - decimals
+	return sign + digits[:i] + "." + digits[i:]
+}
+
+// money formats the value with two decimals.
+func (rc *receipt) money(v any) (string, error) {
+	return rc.number(2, v)
+}
+
+// getenv returns the environment variable, if Options.Env allows it.
+func (p *Parser) getenv(name string) (string, error) {
+	for _, allowed := range p.opts.Env {
+		prefix, isPrefix := strings.CutSuffix(allowed, "*")
+		if allowed == name || isPrefix && strings.HasPrefix(name, prefix) {
+			return os.Getenv(name), nil
+		}
+	}
+	return "", fmt.Errorf("env: environment variable %q is not allowed", name)
+}
+
+// date formats the time with the Go layout.  The value can be time.Time,
+// RFC 3339 string or Unix time in seconds.
+func date(layout string, v any) (string, error) {
+	var t time.Time
+	switch v := v.(type) {
+	case time.Time:
+		t = v
+	case string:
+		var err error
+		if t, err = time.Parse(time.RFC3339, v); err != nil {
+			return "", fmt.Errorf("date: %w", err)
+		}
+	default:
+		sec, err := toFloat(v)
+		if err != nil {
+			return "", fmt.Errorf("date: %w", err)
+		}
+		t = time.Unix(int64(sec), 0)
+	}
+	return t.Format(layout), nil
+}
+
+// repeat repeats the string n times, i.e. for the separator lines.
+func repeat(n int, s string) string {
+	return strings.Repeat(s, max(0, n))
+}
+
+// hexLiteral returns the bytes of the string as senddat hex literals, i.e.
+// "0x41 0x22", it is useful for the text with quotes.
+func hexLiteral(v any) string {
+	s := toString(v)
+	parts := make([]string, len(s))
+	for i := range len(s) {
+		parts[i] = fmt.Sprintf("0x%02X", s[i])
+	}
+	return strings.Join(parts, " ")
+}
+
+// byteLiteral returns the senddat literal for the byte value, i.e. the
+// number of lines to feed: ESC "d" {{ byte .feed }}.
+func byteLiteral(v any) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	if f < 0 || f > 255 || f != float64(int(f)) {
+		return "", fmt.Errorf("byte: value out of range: %v", v)
+	}
+	return fmt.Sprintf("0x%02X", int(f)), nil
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// toFloat converts the numeric value, or the string with a number, to float.
+func toFloat(v any) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	default:
+		return 0, fmt.Errorf("not a number: %v (%T)", v, v)
+	}
+}
+
+// toRat converts the numeric value, or the string with a number, to the exact
+// rational number.  The floats are taken as the shortest decimal, that
+// represents them, i.e. 2.675 and not 2.67499999999999982236....
+func toRat(v any) (*big.Rat, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return parseRat(string(v))
+	case string:
+		return parseRat(v)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Rat).SetUint64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("not a number: %v", v)
+		}
+		return parseRat(strconv.FormatFloat(f, 'f', -1, rv.Type().Bits()))
+	default:
+		return nil, fmt.Errorf("not a number: %v (%T)", v, v)
+	}
+}
+
+func parseRat(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return nil, fmt.Errorf("not a number: %q", s)
+	}
+	return r, nil
+}
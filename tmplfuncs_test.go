@@ -0,0 +1,117 @@
+package senddat
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+func execTemplate(t *testing.T, p *Parser, src string, data any) string {
+	t.Helper()
+	var buf strings.Builder
+	if err := p.ParseFromTemplateWithData(&buf, strings.NewReader(src), data); err != nil {
+		t.Fatalf("ParseFromTemplateWithData() error = %v", err)
+	}
+	return buf.String()
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	ts := time.Date(2025, 3, 7, 14, 5, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		src  string
+		data any
+		want string
+	}{
+		{"padleft", `"[{{ padleft 6 "ab" }}]"`, nil, "[    ab]"},
+		{"padright", `"[{{ padright 6 .n }}]"`, map[string]any{"n": 42}, "[42    ]"},
+		{"padright longer", `"[{{ padright 2 "abcd" }}]"`, nil, "[abcd]"},
+		{"center", `"[{{ center 7 "ab" }}]"`, nil, "[  ab   ]"},
+		{"columns", `"{{ columns 12 "Tea" "1.50" }}"`, nil, "Tea     1.50"},
+		{"columns truncate", `"{{ columns 12 "Chocolate bar" "12.50" }}"`, nil, "Chocol 12.50"},
+		{"columns wide", `"{{ columns 10 "茶" "1.50" }}"`, nil, "茶    1.50"},
+		{"double width", `{{ size 2 2 }}"{{ columns 12 "Sum" "10" }}"{{ size 1 1 }}"{{ center 6 "x" }}"`, nil, "\x1d!\x11Sum 10\x1d!\x00  x   "},
+		{"emphasized", `ESC "!" 0x08 "{{ center 4 "x" }}"`, nil, "\x1b!\x08 x  "},
+		{"raw size without layout", `GS "!" 0x11 "x"`, nil, "\x1d!\x11x"},
+		{"wrap", `{{ range wrap 10 .text }}"{{ . }}" LF {{ end }}`, map[string]any{"text": "Thank you for shopping with us"}, "Thank you\nfor\nshopping\nwith us\n"},
+		{"wrap long word", `{{ range wrap 4 "abcdefghij" }}"{{ . }}" LF {{ end }}`, nil, "abcd\nefgh\nij\n"},
+		{"money", `"{{ money .total }}"`, map[string]any{"total": 1234.5}, "1234.50"},
+		{"money string", `"{{ money "3" }}"`, nil, "3.00"},
+		{"number", `"{{ number 1 .x }}"`, map[string]any{"x": 2.25}, "2.3"},
+		{"money float half", `"{{ money 2.675 }}"`, nil, "2.68"},
+		{"money string half", `"{{ money "1.005" }}"`, nil, "1.01"},
+		{"money json number", `"{{ money .x }}"`, map[string]any{"x": json.Number("0.125")}, "0.13"},
+		{"money negative", `"{{ money "-1.005" }}"`, nil, "-1.01"},
+		{"money negative zero", `"{{ money "-0.004" }}"`, nil, "0.00"},
+		{"money float32", `"{{ money .x }}"`, map[string]any{"x": float32(2.675)}, "2.68"},
+		{"money uint64", `"{{ money .x }}"`, map[string]any{"x": uint64(7)}, "7.00"},
+		{"number int8", `"{{ number 0 .x }}"`, map[string]any{"x": int8(-5)}, "-5"},
+		{"number small", `"{{ number 3 "0.0005" }}"`, nil, "0.001"},
+		{"byte int32", `ESC "d" {{ byte .feed }}`, map[string]any{"feed": int32(3)}, "\x1bd\x03"},
+		{"date", `"{{ date "02.01.2006 15:04" .t }}"`, map[string]any{"t": ts}, "07.03.2025 14:05"},
+		{"date string", `"{{ date "2006-01-02" "2025-03-07T14:05:00Z" }}"`, nil, "2025-03-07"},
+		{"repeat", `"{{ repeat 5 "-" }}"`, nil, "-----"},
+		{"upper lower", `"{{ upper "total" }} {{ lower "SUM" }}"`, nil, "TOTAL sum"},
+		{"hex", `{{ hex "\"Hi\"" }}`, nil, `"Hi"`},
+		{"byte", `ESC "d" {{ byte .feed }}`, map[string]any{"feed": 3.0}, "\x1bd\x03"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, execTemplate(t, testParser, tt.src, tt.data))
+		})
+	}
+}
+
+func TestTemplateFuncs_locale(t *testing.T) {
+	p := NewParser(Options{Output: io.Discard, Locale: language.German})
+	assert.Equal(t, "1.234,50", execTemplate(t, p, `"{{ money 1234.5 }}"`, nil))
+	assert.Equal(t, "9.007.199.254.740.993,01", execTemplate(t, p, `"{{ money "9007199254740993.005" }}"`, nil))
+	p = NewParser(Options{Output: io.Discard, Locale: language.AmericanEnglish})
+	assert.Equal(t, "1,234.50", execTemplate(t, p, `"{{ money 1234.5 }}"`, nil))
+	assert.Equal(t, "1,234.57", execTemplate(t, p, `"{{ money "1234.565" }}"`, nil))
+}
+
+func Test_localizeNumber(t *testing.T) {
+	tests := []struct {
+		locale string
+		s      string
+		want   string
+	}{
+		{"en-US", "-1234567.50", "-1,234,567.50"},
+		{"en-US", "12", "12"},
+		{"en-US", "123456789012345678901.25", "123,456,789,012,345,678,901.25"},
+		{"de", "9007199254740993.01", "9.007.199.254.740.993,01"},
+		{"hi", "1234567.5", "12,34,567.5"},
+		{"fa", "-1234.5", "\u200e−۱٬۲۳۴٫۵"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale+" "+tt.s, func(t *testing.T) {
+			pr := message.NewPrinter(language.MustParse(tt.locale))
+			assert.Equal(t, tt.want, localizeNumber(pr, tt.s))
+		})
+	}
+}
+
+func TestTemplateFuncs_errors(t *testing.T) {
+	for _, src := range []string{
+		`{{ size 9 1 }}`,
+		`{{ byte 256 }}`,
+		`{{ money "abc" }}`,
+		`{{ number -1 1 }}`,
+		`"{{ center 6 "x" }}" GS "!" 0x11 "x"`,
+		`{{ size 2 1 }} ESC "!" 0x00 "{{ center 6 "x" }}"`,
+		`ESC "!" 0x20 "{{ padleft 6 "x" }}"`,
+		`{{ date "2006" "yesterday" }}`,
+	} {
+		t.Run(src, func(t *testing.T) {
+			err := testParser.ParseFromTemplate(io.Discard, strings.NewReader(src))
+			assert.Error(t, err)
+		})
+	}
+}
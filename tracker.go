@@ -0,0 +1,160 @@
+package senddat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// commandTracker follows the commands in the output, as it is written byte
+// by byte, so that the bytes of the arguments and payloads are not taken for
+// the commands.  It is the push counterpart of the [Interpreter].
+type commandTracker struct {
+	root *trieNode
+	node *trieNode // current node of the prefix, nil between commands
+
+	spec    *CommandSpec // command, which arguments or payload are read
+	args    []byte
+	skip    int    // bytes of the fixed size payload left to skip
+	pending []byte // variable size payload read so far
+	// the payload is read again, when pending has retry bytes, or when the
+	// byte wait, if not -1, arrives.
+	retry int
+	wait  int
+}
+
+func newCommandTracker(specs []CommandSpec) *commandTracker {
+	return &commandTracker{root: buildTrie(specs)}
+}
+
+// feed feeds the next byte of the output.  It returns the command and its
+// arguments, when the last argument is read.  The payload of the command is
+// skipped.
+func (t *commandTracker) feed(b byte) (*CommandSpec, []byte) {
+	switch {
+	case t.skip > 0:
+		t.skip--
+		return nil, nil
+	case t.spec != nil && len(t.args) < t.spec.ArgCount:
+		t.args = append(t.args, b)
+		if len(t.args) == t.spec.ArgCount {
+			return t.complete()
+		}
+		return nil, nil
+	case t.spec != nil:
+		t.pending = append(t.pending, b)
+		if len(t.pending) >= t.retry || int(b) == t.wait {
+			t.readPayload()
+		}
+		return nil, nil
+	}
+
+	node := t.node
+	if node == nil {
+		node = t.root
+	}
+	next, ok := node.findChild(b)
+	switch {
+	case !ok && node != t.root:
+		// unknown command, the byte may start the next one.
+		t.node = nil
+		return t.feed(b)
+	case !ok:
+		return nil, nil
+	case next.spec == nil:
+		t.node = next
+		return nil, nil
+	}
+	t.node = nil
+	t.spec = next.spec
+	t.args = t.args[:0]
+	if t.spec.ArgCount == 0 {
+		return t.complete()
+	}
+	return nil, nil
+}
+
+// complete is called when the arguments of the command are read, it sets up
+// the payload.
+func (t *commandTracker) complete() (*CommandSpec, []byte) {
+	cs, args := t.spec, slices.Clone(t.args)
+	switch {
+	case cs.readPayload != nil:
+		t.pending = t.pending[:0]
+		t.readPayload()
+	case cs.payloadFn != nil:
+		if n, err := cs.payloadFn(args); err == nil && n > 0 {
+			t.skip = n
+		}
+		t.spec = nil
+	default:
+		t.spec = nil
+	}
+	return cs, args
+}
+
+// readPayload reads the payload from the pending bytes.  If it is not
+// complete, it is not read again until the bytes, that the payload waits for,
+// arrive, so that the long payloads are read in linear time.
+func (t *commandTracker) readPayload() {
+	r := &bufPayloadReader{buf: t.pending, wait: -1}
+	_, err := t.spec.readPayload(r, t.args)
+	if errors.Is(err, errIncomplete) {
+		t.retry, t.wait = len(t.pending)+r.need, r.wait
+		return
+	}
+	// the payload is read, or it is invalid, and the stream is out of sync
+	// anyway.
+	t.spec = nil
+}
+
+// errIncomplete is returned by bufPayloadReader, if the payload is not
+// written yet.
+var errIncomplete = errors.New("incomplete payload")
+
+// bufPayloadReader reads the payload from the buffer.
+type bufPayloadReader struct {
+	buf []byte
+	// when errIncomplete is returned, the bytes that are missing, and the
+	// terminator that is waited for, or -1.
+	need int
+	wait int
+}
+
+func (r *bufPayloadReader) readByte() (byte, error) {
+	if len(r.buf) == 0 {
+		r.need = 1
+		return 0, errIncomplete
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b, nil
+}
+
+func (r *bufPayloadReader) readBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid read length: %d", n)
+	}
+	if n > len(r.buf) {
+		r.need = n - len(r.buf)
+		return nil, errIncomplete
+	}
+	p := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return p, nil
+}
+
+func (r *bufPayloadReader) readUntil(term byte) ([]byte, error) {
+	n := min(len(r.buf), maxVarPayload)
+	if i := bytes.IndexByte(r.buf[:n], term); i >= 0 {
+		p := r.buf[: i+1 : i+1]
+		r.buf = r.buf[i+1:]
+		return p, nil
+	}
+	if n == maxVarPayload {
+		return nil, fmt.Errorf("no terminator %02X in %d bytes", term, maxVarPayload)
+	}
+	r.need, r.wait = maxVarPayload-n, int(term)
+	return nil, errIncomplete
+}
@@ -0,0 +1,84 @@
+package senddat
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_commandTracker_feed(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream []byte
+		want   []string // commands with the arguments
+	}{
+		{
+			name:   "commands",
+			stream: []byte{0x1B, '@', 'A', 0x1B, 't', 17, 'B'},
+			want:   []string{"1B40 ", "1B74 11"},
+		},
+		{
+			name:   "ESC in arguments",
+			stream: []byte{0x1D, '!', 0x1B, '@'},
+			want:   []string{"1D21 1B"},
+		},
+		{
+			name:   "fixed payload is skipped",
+			stream: []byte{0x1B, '*', 0, 3, 0, 0x1B, 't', 0, 0x1B, '@'},
+			want:   []string{"1B2A 000300", "1B40 "},
+		},
+		{
+			name:   "variable payload is skipped",
+			stream: []byte{0x1B, 'D', 0x1B, '@', 0, 0x1B, '@'},
+			want:   []string{"1B44 ", "1B40 "},
+		},
+		{
+			name:   "long variable payload",
+			stream: slices.Concat([]byte{0x1B, 'D'}, bytes.Repeat([]byte{0x1B, '@'}, 100), []byte{0, 0x1B, 't', 1}),
+			want:   []string{"1B44 ", "1B74 01"},
+		},
+		{
+			name:   "empty variable payload",
+			stream: []byte{0x1B, '&', 3, 2, 1, 0x1B, 't', 1},
+			want:   []string{"1B26 030201", "1B74 01"},
+		},
+		{
+			name:   "unknown command",
+			stream: []byte{0x1B, 0xFF, 0x1B, '@'},
+			want:   []string{"1B40 "},
+		},
+		{
+			name:   "unknown command followed by a command",
+			stream: []byte{0x1D, 0xFF, 0x1B, 't', 2},
+			want:   []string{"1B74 02"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newCommandTracker(GenericCommandSpecs)
+			var got []string
+			for _, b := range tt.stream {
+				if cs, args := tr.feed(b); cs != nil {
+					got = append(got, fmt.Sprintf("%X %X", cs.Prefix, args))
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_commandTracker_feedLongPayload(t *testing.T) {
+	// the payload is not read again for every byte, it would take hours.
+	stream := slices.Concat([]byte{0x1B, 'D'}, bytes.Repeat([]byte{'A'}, maxVarPayload-1), []byte{0, 0x1B, 't', 1})
+	tr := newCommandTracker(GenericCommandSpecs)
+	var got []string
+	for _, b := range stream {
+		if cs, args := tr.feed(b); cs != nil {
+			got = append(got, fmt.Sprintf("%X %X", cs.Prefix, args))
+		}
+	}
+	assert.Equal(t, []string{"1B44 ", "1B74 01"}, got)
+}
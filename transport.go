@@ -0,0 +1,150 @@
+package senddat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultPrinterPort is the raw printing (JetDirect) TCP port.
+const DefaultPrinterPort = "9100"
+
+// Transport is a connection to the printer.
+type Transport interface {
+	io.WriteCloser
+}
+
+// DuplexTransport is a connection to the printer that can also receive the
+// printer responses, i.e. the status.
+type DuplexTransport interface {
+	Transport
+	io.Reader
+	// SetReadDeadline sets the deadline for the future Read calls, zero
+	// time means no deadline.
+	SetReadDeadline(t time.Time) error
+}
+
+// TransportOptions are the connection options.
+type TransportOptions struct {
+	// ConnectTimeout is the maximum time to establish the connection, zero
+	// means no timeout.
+	ConnectTimeout time.Duration
+	// WriteTimeout is the maximum time for a single write, zero means no
+	// timeout.
+	WriteTimeout time.Duration
+}
+
+// ErrUnsupportedScheme is returned by [OpenTransport] for unknown URI schemes.
+var ErrUnsupportedScheme = errors.New("unsupported transport scheme")
+
+// WriteError is returned when the data was written to the printer
+// partially.
+type WriteError struct {
+	// Written is the total number of bytes written to the transport so far.
+	Written int64
+	// Pending is the number of bytes of the failed write that were not
+	// written.
+	Pending int
+	Err     error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("write failed after %d bytes, %d bytes not written: %s", e.Written, e.Pending, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransportURI returns true if s looks like the transport URI, i.e.
+// "tcp://printer:9100", and not like a filename.
+func IsTransportURI(s string) bool {
+	scheme, _, ok := strings.Cut(s, "://")
+	return ok && scheme != "" && !strings.ContainsAny(scheme, `/\.`)
+}
+
+// OpenTransport opens the connection to the printer by its URI.  Supported
+// schemes are:
+//
+//   - tcp://host[:port] - raw TCP connection, port defaults to 9100;
+//   - serial:///dev/ttyUSB0?baud=38400&parity=none&flow=rtscts - serial port,
+//     parameters are baud, databits (7, 8), stopbits (1, 2), parity (none,
+//     even, odd) and flow (none, rtscts, dtrdsr, xonxoff).
+func OpenTransport(uri string, opts TransportOptions) (Transport, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transport URI %q: %w", uri, err)
+	}
+	var t Transport
+	switch u.Scheme {
+	case "tcp":
+		t, err = DialTCP(u.Host, opts)
+	case "serial":
+		device, sopts, perr := parseSerialURI(u)
+		if perr != nil {
+			return nil, perr
+		}
+		t, err = OpenSerial(device, sopts, opts)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TCPTransport is a raw TCP connection to the printer.
+type TCPTransport struct {
+	conn    net.Conn
+	opts    TransportOptions
+	written int64
+}
+
+// DialTCP connects to the printer at addr.  If addr has no port, the
+// DefaultPrinterPort is used.
+func DialTCP(addr string, opts TransportOptions) (*TCPTransport, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), DefaultPrinterPort)
+	}
+	conn, err := net.DialTimeout("tcp", addr, opts.ConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	return &TCPTransport{conn: conn, opts: opts}, nil
+}
+
+// Write writes data to the printer.  If the write fails, the error is
+// [*WriteError] with the number of bytes written.
+func (t *TCPTransport) Write(p []byte) (int, error) {
+	if t.opts.WriteTimeout > 0 {
+		if err := t.conn.SetWriteDeadline(time.Now().Add(t.opts.WriteTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := t.conn.Write(p)
+	t.written += int64(n)
+	if err != nil {
+		return n, &WriteError{Written: t.written, Pending: len(p) - n, Err: err}
+	}
+	return n, nil
+}
+
+// Read reads the data sent by the printer.
+func (t *TCPTransport) Read(p []byte) (int, error) {
+	return t.conn.Read(p)
+}
+
+// SetReadDeadline sets the read deadline on the connection.
+func (t *TCPTransport) SetReadDeadline(tm time.Time) error {
+	return t.conn.SetReadDeadline(tm)
+}
+
+// Close closes the connection.
+func (t *TCPTransport) Close() error {
+	return t.conn.Close()
+}
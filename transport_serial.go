@@ -0,0 +1,136 @@
+package senddat
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Parity is the serial port parity.
+type Parity int
+
+const (
+	ParityNone Parity = iota
+	ParityEven
+	ParityOdd
+)
+
+// FlowControl is the serial port flow control.
+type FlowControl int
+
+const (
+	FlowNone FlowControl = iota
+	// FlowRTSCTS is the hardware RTS/CTS flow control.
+	FlowRTSCTS
+	// FlowDTRDSR is the DTR/DSR flow control, data is sent only while the
+	// printer asserts DSR.
+	FlowDTRDSR
+	// FlowXONXOFF is the software XON/XOFF flow control.
+	FlowXONXOFF
+)
+
+// SerialOptions are the serial port parameters.
+type SerialOptions struct {
+	Baud     int
+	DataBits int // 7 or 8
+	StopBits int // 1 or 2
+	Parity   Parity
+	Flow     FlowControl
+}
+
+// DefaultSerialOptions are the serial port parameters used if the URI does
+// not specify them, 9600 8N1 without flow control, which is the factory
+// default for most printers.
+var DefaultSerialOptions = SerialOptions{
+	Baud:     9600,
+	DataBits: 8,
+	StopBits: 1,
+	Parity:   ParityNone,
+	Flow:     FlowNone,
+}
+
+// ErrSerialUnsupported is returned when serial ports are not supported on
+// the platform.
+var ErrSerialUnsupported = errors.New("serial ports are not supported on this platform")
+
+var errInvalidSerialParam = errors.New("invalid serial port parameter")
+
+// parseSerialURI parses the serial port URI, i.e.
+//
+//	serial:///dev/ttyUSB0?baud=38400&parity=none&flow=rtscts
+//
+// and returns the device name and the port parameters.
+func parseSerialURI(u *url.URL) (string, SerialOptions, error) {
+	opts := DefaultSerialOptions
+	device := u.Path
+	if device == "" {
+		device = u.Opaque // serial:COM1
+	}
+	if u.Host != "" {
+		device = u.Host + device // serial://COM1
+	}
+	if device == "" {
+		return "", opts, errors.New("missing serial device")
+	}
+	for key, values := range u.Query() {
+		value := strings.ToLower(values[len(values)-1])
+		if err := opts.set(strings.ToLower(key), value); err != nil {
+			return "", opts, err
+		}
+	}
+	return device, opts, nil
+}
+
+// set sets the option by its URI parameter name.
+func (opts *SerialOptions) set(key, value string) error {
+	invalid := fmt.Errorf("%w: %s=%s", errInvalidSerialParam, key, value)
+	switch key {
+	case "baud":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return invalid
+		}
+		opts.Baud = n
+	case "databits":
+		n, err := strconv.Atoi(value)
+		if err != nil || (n != 7 && n != 8) {
+			return invalid
+		}
+		opts.DataBits = n
+	case "stopbits":
+		n, err := strconv.Atoi(value)
+		if err != nil || (n != 1 && n != 2) {
+			return invalid
+		}
+		opts.StopBits = n
+	case "parity":
+		switch value {
+		case "none", "n":
+			opts.Parity = ParityNone
+		case "even", "e":
+			opts.Parity = ParityEven
+		case "odd", "o":
+			opts.Parity = ParityOdd
+		default:
+			return invalid
+		}
+	case "flow":
+		switch value {
+		case "none", "":
+			opts.Flow = FlowNone
+		case "rtscts", "hardware":
+			opts.Flow = FlowRTSCTS
+		case "dtrdsr":
+			opts.Flow = FlowDTRDSR
+		case "xonxoff", "software":
+			opts.Flow = FlowXONXOFF
+		default:
+			return invalid
+		}
+	default:
+		return fmt.Errorf("%w: unknown parameter %q", errInvalidSerialParam, key)
+	}
+	return nil
+}
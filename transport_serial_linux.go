@@ -0,0 +1,208 @@
+//go:build linux
+
+package senddat
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// dsrPollInterval is the interval of checking the DSR line with DTR/DSR flow
+// control.
+const dsrPollInterval = 10 * time.Millisecond
+
+// dsrChunkSize is the largest write between the DSR checks with DTR/DSR flow
+// control.
+const dsrChunkSize = 256
+
+var baudRates = map[int]uint32{
+	1200:   unix.B1200,
+	2400:   unix.B2400,
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+}
+
+// SerialTransport is the serial port connection to the printer.
+type SerialTransport struct {
+	f       *os.File
+	rc      syscall.RawConn
+	opts    SerialOptions
+	topts   TransportOptions
+	written int64
+}
+
+// OpenSerial opens and configures the serial port device.
+func OpenSerial(device string, opts SerialOptions, topts TransportOptions) (*SerialTransport, error) {
+	speed, ok := baudRates[opts.Baud]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported baud rate %d", errInvalidSerialParam, opts.Baud)
+	}
+	f, err := os.OpenFile(device, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serial port: %w", err)
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	st := &SerialTransport{f: f, rc: rc, opts: opts, topts: topts}
+	if err := st.configure(speed); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to configure serial port %s: %w", device, err)
+	}
+	return st, nil
+}
+
+// control calls fn with the file descriptor.
+func (st *SerialTransport) control(fn func(fd int) error) error {
+	var err error
+	if cerr := st.rc.Control(func(fd uintptr) { err = fn(int(fd)) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// configure sets the port to raw mode with the port parameters.
+func (st *SerialTransport) configure(speed uint32) error {
+	return st.control(func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			return err
+		}
+		// raw mode, as cfmakeraw(3) does.
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF | unix.IXANY | unix.INPCK
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CRTSCTS | unix.CBAUD
+		t.Cflag |= unix.CREAD | unix.CLOCAL | speed
+		t.Ispeed, t.Ospeed = speed, speed
+		t.Cc[unix.VMIN], t.Cc[unix.VTIME] = 1, 0
+
+		if st.opts.DataBits == 7 {
+			t.Cflag |= unix.CS7
+		} else {
+			t.Cflag |= unix.CS8
+		}
+		if st.opts.StopBits == 2 {
+			t.Cflag |= unix.CSTOPB
+		}
+		switch st.opts.Parity {
+		case ParityEven:
+			t.Cflag |= unix.PARENB
+			t.Iflag |= unix.INPCK
+		case ParityOdd:
+			t.Cflag |= unix.PARENB | unix.PARODD
+			t.Iflag |= unix.INPCK
+		}
+		switch st.opts.Flow {
+		case FlowRTSCTS:
+			t.Cflag |= unix.CRTSCTS
+		case FlowXONXOFF:
+			t.Iflag |= unix.IXON | unix.IXOFF
+		}
+		if err := unix.IoctlSetTermios(fd, unix.TCSETS, t); err != nil {
+			return err
+		}
+		if st.opts.Flow == FlowDTRDSR {
+			// DTR signals the printer that we are ready, and DSR must be
+			// readable to follow the printer state.
+			if err := unix.IoctlSetPointerInt(fd, unix.TIOCMBIS, unix.TIOCM_DTR); err != nil {
+				return fmt.Errorf("dtrdsr flow control is not supported by the device: %w", err)
+			}
+			if _, err := unix.IoctlGetInt(fd, unix.TIOCMGET); err != nil {
+				return fmt.Errorf("dtrdsr flow control is not supported by the device: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// dsr returns true if the printer asserts DSR.
+func (st *SerialTransport) dsr() (bool, error) {
+	var status int
+	err := st.control(func(fd int) error {
+		var err error
+		status, err = unix.IoctlGetInt(fd, unix.TIOCMGET)
+		return err
+	})
+	return status&unix.TIOCM_DSR != 0, err
+}
+
+// waitDSR waits until the printer asserts DSR, or the deadline passes.
+func (st *SerialTransport) waitDSR(deadline time.Time) error {
+	for {
+		ready, err := st.dsr()
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fmt.Errorf("printer is not ready (DSR): %w", os.ErrDeadlineExceeded)
+		}
+		time.Sleep(dsrPollInterval)
+	}
+}
+
+// Write writes data to the printer.  If the write fails, the error is
+// [*WriteError] with the number of bytes written.
+func (st *SerialTransport) Write(p []byte) (int, error) {
+	var deadline time.Time
+	if st.topts.WriteTimeout > 0 {
+		deadline = time.Now().Add(st.topts.WriteTimeout)
+		if err := st.f.SetWriteDeadline(deadline); err != nil && !errors.Is(err, os.ErrNoDeadline) {
+			return 0, err
+		}
+	}
+	if st.opts.Flow != FlowDTRDSR {
+		n, err := st.f.Write(p)
+		st.written += int64(n)
+		if err != nil {
+			return n, &WriteError{Written: st.written, Pending: len(p) - n, Err: err}
+		}
+		return n, nil
+	}
+	// DSR is checked before each chunk, so that the printer can stop the
+	// data in the middle of a large write, i.e. a raster image.
+	var total int
+	for len(p) > 0 {
+		if err := st.waitDSR(deadline); err != nil {
+			return total, &WriteError{Written: st.written, Pending: len(p), Err: err}
+		}
+		n, err := st.f.Write(p[:min(len(p), dsrChunkSize)])
+		st.written += int64(n)
+		total += n
+		p = p[n:]
+		if err != nil {
+			return total, &WriteError{Written: st.written, Pending: len(p), Err: err}
+		}
+	}
+	return total, nil
+}
+
+// Read reads the data sent by the printer.
+func (st *SerialTransport) Read(p []byte) (int, error) {
+	return st.f.Read(p)
+}
+
+// SetReadDeadline sets the read deadline on the port.
+func (st *SerialTransport) SetReadDeadline(t time.Time) error {
+	return st.f.SetReadDeadline(t)
+}
+
+// Close closes the serial port.
+func (st *SerialTransport) Close() error {
+	return st.f.Close()
+}
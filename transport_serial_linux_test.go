@@ -0,0 +1,130 @@
+//go:build linux
+
+package senddat
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens the pseudo-terminal pair and returns the master and the name
+// of the slave device.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminals are not available: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatalf("unlockpt: %v", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatalf("ptsname: %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestOpenTransport_serial(t *testing.T) {
+	master, slave := openPTY(t)
+	tr, err := OpenTransport("serial://"+slave+"?baud=38400&parity=even&flow=rtscts", TransportOptions{WriteTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("OpenTransport() error = %v", err)
+	}
+	st := tr.(*SerialTransport)
+
+	var tio *unix.Termios
+	err = st.control(func(fd int) error {
+		var err error
+		tio, err = unix.IoctlGetTermios(fd, unix.TCGETS)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint32(unix.B38400), tio.Cflag&unix.CBAUD, "baud")
+	// pty driver always resets the parity bits of c_cflag.
+	assert.NotZero(t, tio.Iflag&unix.INPCK, "parity check")
+	assert.NotZero(t, tio.Cflag&unix.CRTSCTS, "rts/cts")
+	assert.Zero(t, tio.Lflag&unix.ICANON, "raw mode")
+	assert.Zero(t, tio.Oflag&unix.OPOST, "no output processing")
+
+	if err := testParser.Parse(tr, strings.NewReader(`ESC "@" "Hello" LF`)); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []byte("\x1b@Hello\n")
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(master, got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, want, got)
+	assert.NoError(t, tr.Close())
+}
+
+func TestOpenSerial_xonxoff(t *testing.T) {
+	_, slave := openPTY(t)
+	st, err := OpenSerial(slave, SerialOptions{Baud: 9600, DataBits: 8, StopBits: 1, Flow: FlowXONXOFF}, TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	var tio *unix.Termios
+	err = st.control(func(fd int) error {
+		var err error
+		tio, err = unix.IoctlGetTermios(fd, unix.TCGETS)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotZero(t, tio.Iflag&unix.IXON)
+	assert.NotZero(t, tio.Iflag&unix.IXOFF)
+	assert.Zero(t, tio.Cflag&unix.CRTSCTS)
+}
+
+func TestOpenSerial_errors(t *testing.T) {
+	_, slave := openPTY(t)
+	_, err := OpenSerial(slave, SerialOptions{Baud: 12345, DataBits: 8, StopBits: 1}, TransportOptions{})
+	assert.ErrorIs(t, err, errInvalidSerialParam, "unsupported baud rate")
+
+	// pseudo-terminals do not have modem control lines.
+	_, err = OpenSerial(slave, SerialOptions{Baud: 9600, DataBits: 8, StopBits: 1, Flow: FlowDTRDSR}, TransportOptions{})
+	assert.Error(t, err, "dtr/dsr on pty")
+
+	_, err = OpenSerial("/dev/nonexistent-tty", DefaultSerialOptions, TransportOptions{})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSerialTransport_status(t *testing.T) {
+	master, slave := openPTY(t)
+	st, err := OpenSerial(slave, DefaultSerialOptions, TransportOptions{WriteTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	go func() {
+		// answer four DLE EOT n requests: offline, cover open.
+		resp := []byte{0x1a, 0x16, 0x12, 0x12}
+		req := make([]byte, 3)
+		for _, b := range resp {
+			if _, err := io.ReadFull(master, req); err != nil {
+				return
+			}
+			master.Write([]byte{b})
+		}
+	}()
+	got, err := QueryStatus(st, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Status{Offline: true, CoverOpen: true}, got)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package senddat
+
+import (
+	"fmt"
+	"os"
+)
+
+// SerialTransport is the serial port connection to the printer.
+type SerialTransport struct {
+	*os.File
+}
+
+// OpenSerial opens and configures the serial port device.
+func OpenSerial(device string, opts SerialOptions, topts TransportOptions) (*SerialTransport, error) {
+	return nil, fmt.Errorf("%w: %s", ErrSerialUnsupported, device)
+}
@@ -0,0 +1,70 @@
+package senddat
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseSerialURI(t *testing.T) {
+	tests := []struct {
+		name       string
+		uri        string
+		wantDevice string
+		wantOpts   SerialOptions
+		wantErr    bool
+	}{
+		{
+			name:       "defaults",
+			uri:        "serial:///dev/ttyUSB0",
+			wantDevice: "/dev/ttyUSB0",
+			wantOpts:   DefaultSerialOptions,
+		},
+		{
+			name:       "all parameters",
+			uri:        "serial:///dev/ttyS1?baud=38400&parity=even&flow=rtscts&databits=7&stopbits=2",
+			wantDevice: "/dev/ttyS1",
+			wantOpts:   SerialOptions{Baud: 38400, DataBits: 7, StopBits: 2, Parity: ParityEven, Flow: FlowRTSCTS},
+		},
+		{
+			name:       "windows port",
+			uri:        "serial://COM3?flow=xonxoff",
+			wantDevice: "COM3",
+			wantOpts:   SerialOptions{Baud: 9600, DataBits: 8, StopBits: 1, Flow: FlowXONXOFF},
+		},
+		{
+			name:    "invalid parity",
+			uri:     "serial:///dev/ttyS0?parity=mark",
+			wantErr: true,
+		},
+		{
+			name:    "unknown parameter",
+			uri:     "serial:///dev/ttyS0?speed=9600",
+			wantErr: true,
+		},
+		{
+			name:    "no device",
+			uri:     "serial://",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			device, opts, err := parseSerialURI(u)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSerialURI() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equal(t, tt.wantDevice, device)
+			assert.Equal(t, tt.wantOpts, opts)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package senddat
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePrinter is a TCP listener that accepts one connection and records the
+// received data.
+type fakePrinter struct {
+	l    net.Listener
+	done chan []byte
+}
+
+func newFakePrinter(t *testing.T, read bool) *fakePrinter {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := &fakePrinter{l: l, done: make(chan []byte, 1)}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			fp.done <- nil
+			return
+		}
+		defer conn.Close()
+		if !read {
+			// printer that does not accept data.
+			time.Sleep(time.Second)
+			fp.done <- nil
+			return
+		}
+		data, _ := io.ReadAll(conn)
+		fp.done <- data
+	}()
+	t.Cleanup(func() { l.Close() })
+	return fp
+}
+
+func (fp *fakePrinter) uri() string {
+	return "tcp://" + fp.l.Addr().String()
+}
+
+func TestOpenTransport_tcp(t *testing.T) {
+	fp := newFakePrinter(t, true)
+	tr, err := OpenTransport(fp.uri(), TransportOptions{ConnectTimeout: time.Second, WriteTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testParser.Parse(tr, strings.NewReader(`ESC "@" "Hello" LF *10 GS "V" 0`)); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte("\x1b@Hello\n\x1dV\x00"), <-fp.done)
+}
+
+func TestOpenTransport_errors(t *testing.T) {
+	_, err := OpenTransport("lpd://printer/queue", TransportOptions{})
+	assert.ErrorIs(t, err, ErrUnsupportedScheme)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, err = OpenTransport("tcp://"+addr, TransportOptions{ConnectTimeout: time.Second})
+	assert.Error(t, err, "connection refused")
+}
+
+func TestTCPTransport_writeTimeout(t *testing.T) {
+	fp := newFakePrinter(t, false)
+	tr, err := DialTCP(fp.l.Addr().String(), TransportOptions{WriteTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	data := make([]byte, 64<<20)
+	n, err := tr.Write(data)
+	var we *WriteError
+	if !errors.As(err, &we) {
+		t.Fatalf("Write() error = %v, want WriteError", err)
+	}
+	var ne net.Error
+	assert.True(t, errors.As(err, &ne) && ne.Timeout(), "timeout error")
+	assert.Equal(t, int64(n), we.Written)
+	assert.Equal(t, len(data)-n, we.Pending)
+	assert.Less(t, n, len(data))
+}
+
+func TestIsTransportURI(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"tcp://192.168.1.50:9100", true},
+		{"serial:///dev/ttyUSB0", true},
+		{"output.prn", false},
+		{"./dir://file", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsTransportURI(tt.s))
+		})
+	}
+}
+
+// writeRecorder records every write.
+type writeRecorder struct {
+	writes [][]byte
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, bytes.Clone(p))
+	return len(p), nil
+}
+
+func TestParse_delayFlushes(t *testing.T) {
+	var w writeRecorder
+	if err := testParser.Parse(&w, strings.NewReader(`"AB" *1 "CD"`)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [][]byte{[]byte("AB"), []byte("CD")}, w.writes)
+}